@@ -47,12 +47,23 @@ Filters can be set on Fqdns and tags.`,
 			stage = currentStage.Filter(pFqdns, pTags)
 		}
 
+		run := NewCommandRun("check", stage, pRepositories)
+
 		if pAllRepositories {
 			stage.CheckAll()
 		} else {
-			stage.Check(pRepositories)
+			repositories, err := stage.ExpandRepositories(pRepositories)
+			if err != nil {
+				ErrorExit(err.Error())
+			}
+			RenderRepositoryList(repositories)
+			run.Repositories = repositories
+			stage.Check(repositories)
 		}
 
+		run.Finish(stage)
+		EndRun(run, stage)
+
 		if stage.HasError() {
 			RenderErrorSummary(stage)
 		}
@@ -62,6 +73,9 @@ Filters can be set on Fqdns and tags.`,
 func init() {
 	pulpCmd.AddCommand(checkCmd)
 
+	checkCmd.Flags().StringVar(&pJunit, "junit", "", "Write a JUnit XML report of the check to this file")
+	checkCmd.Flags().StringVar(&pHtmlReport, "html-report", "", "Write a self-contained HTML report of the check to this file")
+
 	// Here you will define your flags and configuration settings.
 
 	// Cobra supports Persistent Flags which will work for this command
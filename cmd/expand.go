@@ -0,0 +1,57 @@
+// Copyright © 2016 Marc Sutter <marc.sutter@swissflow.ch>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/msutter/nodetree/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// expandCmd represents the expand command
+var expandCmd = &cobra.Command{
+	Use:   "expand [stage name]",
+	Short: "Print the expanded stage tree",
+	Long: `Print the expanded stage tree
+
+Includes are merged, templates are instantiated and variables are interpolated.
+Without a stage name, all stages are printed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 1 {
+			ErrorExitWithUsage(cmd, "expand takes at most one stage name")
+		}
+
+		expandedTree := stageTree
+		if len(args) == 1 {
+			stage := stageTree.GetStageByName(args[0])
+			if stage == nil {
+				ErrorExit(fmt.Sprintf("stage '%v' not found\n", args[0]))
+			}
+			expandedTree.Stages = []*models.Stage{stage}
+		}
+
+		// the passwords and secrets are not printed
+		out, err := yaml.Marshal(expandedTree.Redacted())
+		if err != nil {
+			ErrorExit(err.Error())
+		}
+		fmt.Printf("---\n%s", out)
+	},
+}
+
+func init() {
+	treeCmd.AddCommand(expandCmd)
+}
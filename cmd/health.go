@@ -0,0 +1,108 @@
+// Copyright © 2016 Marc Sutter <marc.sutter@swissflow.ch>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	tm "github.com/buger/goterm"
+	"github.com/msutter/nodetree/models"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+// healthCmd represents the health command
+var healthCmd = &cobra.Command{
+	Use:   "health [stage name]",
+	Short: "Health of the pulp nodes for a given stage",
+	Long: `Health of the pulp nodes in a given stage
+
+Queries the status api of every node: pulp version, database and messaging
+connectivity and live workers. Exits nonzero if any node is unhealthy.
+
+Filters can be set on Fqdns and tags.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			ErrorExitWithUsage(cmd, "health needs a name for the stage")
+		}
+
+		// check for flags
+		if len(pFqdns) == 0 && len(pTags) == 0 {
+			pAllNode = true
+		}
+
+		currentStage := stageTree.GetStageByName(args[0])
+
+		var stage *models.Stage
+
+		if pAllNode {
+			stage = currentStage
+		} else {
+			stage = currentStage.Filter(pFqdns, pTags)
+		}
+
+		stage.Health()
+
+		if !pSilent {
+			RenderHealthTree(stage)
+			fmt.Printf("\n")
+		}
+
+		if stage.HasError() {
+			if !pSilent {
+				RenderErrorSummary(stage)
+			}
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	pulpCmd.AddCommand(healthCmd)
+}
+
+// tree view annotated with the server status
+func RenderHealthTree(s *models.Stage) {
+	s.NodeTreeWalker(s.PulpRootNode, func(n *models.Node) {
+		if !n.Reachable {
+			line := fmt.Sprintf("%v unreachable", n.Fqdn)
+			fmt.Print(tm.Color(n.GetTreeRaw(line), tm.RED))
+			fmt.Printf("\n")
+			return
+		}
+
+		state := tm.Color("healthy", tm.GREEN)
+		if n.HasError() {
+			state = tm.Color("unhealthy", tm.RED)
+		}
+		line := fmt.Sprintf("%v %v pulp %v, database %v, messaging %v, %v workers, %vms",
+			n.Fqdn,
+			state,
+			n.Status.Versions.PlatformVersion,
+			connectedString(n.Status.DatabaseConnection.Connected),
+			connectedString(n.Status.MessagingConnection.Connected),
+			n.Status.LiveWorkers(),
+			n.ApiLatency.Nanoseconds()/int64(time.Millisecond))
+		fmt.Print(n.GetTreeRaw(line))
+		fmt.Printf("\n")
+	})
+}
+
+func connectedString(connected bool) string {
+	if connected {
+		return "connected"
+	}
+	return tm.Color("disconnected", tm.RED)
+}
@@ -0,0 +1,34 @@
+// Copyright © 2016 Marc Sutter <marc.sutter@swissflow.ch>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Query the history of the sync and check runs",
+	Long: `Query the history of the sync and check runs
+
+This is the history namespace.
+The runs are kept in the 'runs' directory of the state dir.`,
+	// No run function here
+}
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+}
@@ -0,0 +1,75 @@
+// Copyright © 2016 Marc Sutter <marc.sutter@swissflow.ch>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/msutter/nodetree/models"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var pHistoryStage string
+var pHistoryLimit int
+
+// historyListCmd represents the history list command
+var historyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the runs, newest first",
+	Long: `List the runs, newest first
+
+Shows the command, stage, trigger, user, start, duration, state
+and the repository counts of each run.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			ErrorExitWithUsage(cmd, "no arguments allowed for history list")
+		}
+
+		runs, err := models.LoadRuns(pHistoryLimit, func(run *models.Run) bool {
+			return pHistoryStage == "" || run.Stage == pHistoryStage
+		})
+		if err != nil {
+			ErrorExit(err.Error())
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "ID\tCOMMAND\tSTAGE\tTRIGGER\tUSER\tSTART\tDURATION\tSTATE\tOK\tFAILED\tSKIPPED\n")
+		for _, run := range runs {
+			counts := run.Counts()
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+				run.Id,
+				run.Command,
+				run.Stage,
+				run.Trigger,
+				run.User,
+				run.Start.Format(time.RFC3339),
+				models.FormatDuration(run.Start, run.End),
+				run.State,
+				counts.Finished,
+				counts.Failed,
+				counts.Skipped)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	historyCmd.AddCommand(historyListCmd)
+
+	historyListCmd.Flags().StringVar(&pHistoryStage, "stage", "", "Only list the runs of this stage")
+	historyListCmd.Flags().IntVarP(&pHistoryLimit, "limit", "n", 20, "Maximum number of runs to list, 0 for all")
+}
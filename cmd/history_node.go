@@ -0,0 +1,86 @@
+// Copyright © 2016 Marc Sutter <marc.sutter@swissflow.ch>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/msutter/nodetree/models"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// historyNodeCmd represents the history node command
+var historyNodeCmd = &cobra.Command{
+	Use:   "node [fqdn]",
+	Short: "Show the results of a node over all runs",
+	Long: `Show the results of a node over all runs, newest first
+
+Shows the outcome of each repository of the node with its task id,
+duration and size, and the node errors of each run.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			ErrorExitWithUsage(cmd, "history node needs a fqdn")
+		}
+		fqdn := args[0]
+
+		runs, err := models.LoadRuns(pHistoryLimit, func(run *models.Run) bool {
+			_, inRun := run.Nodes[fqdn]
+			return inRun
+		})
+		if err != nil {
+			ErrorExit(err.Error())
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "RUN\tCOMMAND\tSTART\tREPOSITORY\tSTATE\tTASK\tDURATION\tSIZE\tERROR\n")
+		for _, run := range runs {
+			for _, e := range run.Nodes[fqdn].Errors {
+				fmt.Fprintf(w, "%v\t%v\t%v\t-\terror\t-\t-\t-\t%v\n",
+					run.Id,
+					run.Command,
+					run.Start.Format(time.RFC3339),
+					e)
+			}
+			for _, result := range run.Results {
+				if result.Fqdn != fqdn {
+					continue
+				}
+				fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+					run.Id,
+					run.Command,
+					run.Start.Format(time.RFC3339),
+					result.Repository,
+					result.State,
+					valueOrDash(result.TaskId),
+					models.FormatDuration(result.Start, result.End),
+					models.FormatBytes(result.SizeTotal),
+					valueOrDash(result.Error))
+			}
+		}
+		w.Flush()
+
+		if len(runs) == 0 {
+			ErrorExit(fmt.Sprintf("no runs found for node '%v'\n", fqdn))
+		}
+	},
+}
+
+func init() {
+	historyCmd.AddCommand(historyNodeCmd)
+
+	historyNodeCmd.Flags().IntVarP(&pHistoryLimit, "limit", "n", 20, "Maximum number of runs to show, 0 for all")
+}
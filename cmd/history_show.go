@@ -0,0 +1,127 @@
+// Copyright © 2016 Marc Sutter <marc.sutter@swissflow.ch>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/msutter/nodetree/models"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// historyShowCmd represents the history show command
+var historyShowCmd = &cobra.Command{
+	Use:   "show [run id]",
+	Short: "Show the results of a run",
+	Long: `Show the results of a run
+
+Shows the filters and repositories of the run, the outcome of each
+node and repository with its task id, duration and size, and the
+node errors. A unique prefix of the run id is enough.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			ErrorExitWithUsage(cmd, "history show needs a run id")
+		}
+
+		run, err := models.FindRun(args[0])
+		if err != nil {
+			ErrorExit(err.Error())
+		}
+		RenderRun(run)
+	},
+}
+
+func init() {
+	historyCmd.AddCommand(historyShowCmd)
+}
+
+func RenderRun(run *models.Run) {
+	fmt.Printf("run:          %v\n", run.Id)
+	fmt.Printf("command:      %v\n", run.Command)
+	fmt.Printf("stage:        %v\n", run.Stage)
+	fmt.Printf("trigger:      %v\n", run.Trigger)
+	fmt.Printf("user:         %v\n", run.User)
+	fmt.Printf("start:        %v\n", run.Start.Format(time.RFC3339))
+	fmt.Printf("end:          %v\n", run.End.Format(time.RFC3339))
+	fmt.Printf("duration:     %v\n", models.FormatDuration(run.Start, run.End))
+	fmt.Printf("state:        %v\n", run.State)
+	if run.StageEtaSeconds > 0 {
+		fmt.Printf("stage eta:    %v\n", models.FormatSeconds(run.StageEtaSeconds))
+	}
+	if run.Message != "" {
+		fmt.Printf("message:      %v\n", run.Message)
+	}
+	if len(run.Fqdns) > 0 {
+		fmt.Printf("fqdns:        %v\n", strings.Join(run.Fqdns, ", "))
+	}
+	if len(run.Tags) > 0 {
+		fmt.Printf("tags:         %v\n", strings.Join(run.Tags, ", "))
+	}
+	fmt.Printf("repositories: %v\n", strings.Join(run.Repositories, ", "))
+
+	if len(run.Results) > 0 {
+		fmt.Printf("\n")
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "NODE\tREPOSITORY\tSTATE\tTASK\tDURATION\tSIZE\tITEMS\tTHROUGHPUT\tETA\n")
+		for _, result := range run.Results {
+			eta := "-"
+			if result.EtaSeconds > 0 {
+				eta = models.FormatSeconds(result.EtaSeconds)
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+				result.Fqdn,
+				result.Repository,
+				result.State,
+				valueOrDash(result.TaskId),
+				models.FormatDuration(result.Start, result.End),
+				models.FormatBytes(result.SizeTotal),
+				result.ItemsTotal,
+				models.FormatThroughput(result.Throughput),
+				eta)
+		}
+		w.Flush()
+	}
+
+	fqdns := []string{}
+	for fqdn, ns := range run.Nodes {
+		if ns.HasError() {
+			fqdns = append(fqdns, fqdn)
+		}
+	}
+	sort.Strings(fqdns)
+	if len(fqdns) > 0 {
+		fmt.Printf("\nerrors:\n")
+	}
+	for _, fqdn := range fqdns {
+		fmt.Printf("  %v\n", fqdn)
+		for _, e := range run.Nodes[fqdn].Errors {
+			fmt.Printf("   - %v\n", e)
+		}
+		for repository, e := range run.Nodes[fqdn].RepositoryError {
+			fmt.Printf("   - %v: %v\n", repository, e)
+		}
+	}
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,115 @@
+// Copyright © 2016 Marc Sutter <marc.sutter@swissflow.ch>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/msutter/nodetree/models"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+)
+
+var pImportFrom string
+var pImportParentVar string
+var pImportStageVar string
+var pImportStage string
+var pImportOutput string
+var pImportCompare bool
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import [inventory file]",
+	Short: "Import the stage tree from an ansible inventory",
+	Long: `Import the stage tree from an ansible inventory
+
+Reads an INI or YAML inventory. A host variable names the parent node
+(--parent-var), hosts without parent are the root nodes of the stages
+(named by --stage-var or --stage). Host groups become the node tags.
+
+The imported tree is written as yaml, or compared with the current
+configuration when --compare is set.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			ErrorExitWithUsage(cmd, "import needs an inventory file")
+		}
+
+		if pImportFrom != "ansible-inventory" {
+			ErrorExitWithUsage(cmd, fmt.Sprintf("unsupported import source '%v'\n", pImportFrom))
+		}
+
+		inventory, err := models.ReadAnsibleInventory(args[0])
+		if err != nil {
+			ErrorExit(fmt.Sprintf("%v\n", err))
+		}
+
+		importedTree, err := inventory.StageTree(pImportParentVar, pImportStageVar, pImportStage)
+		if err != nil {
+			ErrorExit(fmt.Sprintf("%v: %v\n", args[0], err))
+		}
+		importedTree.Description = fmt.Sprintf("Imported from ansible inventory %v", args[0])
+
+		if pImportCompare {
+			// apply the same tag inheritance as the current configuration
+			importedTree.InheritTags = stageTree.InheritTags
+			for _, importedStage := range importedTree.Stages {
+				if stage := stageTree.GetStageByName(importedStage.Name); stage != nil {
+					importedStage.InheritTags = stage.InheritTags
+				}
+			}
+			importedTree.SetFile(args[0])
+			importedTree.Init()
+
+			differences := stageTree.Compare(&importedTree)
+			for _, difference := range differences {
+				fmt.Println(difference)
+			}
+			if len(differences) > 0 {
+				os.Exit(1)
+			}
+			if !pSilent {
+				fmt.Println("no differences found")
+			}
+			return
+		}
+
+		out, err := yaml.Marshal(&importedTree)
+		if err != nil {
+			ErrorExit(err.Error())
+		}
+		out = append([]byte("---\n"), out...)
+
+		if pImportOutput == "" {
+			fmt.Printf("%s", out)
+			return
+		}
+		err = ioutil.WriteFile(pImportOutput, out, 0644)
+		if err != nil {
+			ErrorExit(fmt.Sprintf("%v\n", err))
+		}
+	},
+}
+
+func init() {
+	treeCmd.AddCommand(importCmd)
+
+	importCmd.Flags().StringVar(&pImportFrom, "from", "ansible-inventory", "The import source. Only 'ansible-inventory' is supported")
+	importCmd.Flags().StringVar(&pImportParentVar, "parent-var", "pulp_parent", "The host variable naming the parent node")
+	importCmd.Flags().StringVar(&pImportStageVar, "stage-var", "pulp_stage", "The host variable naming the stage of a root node")
+	importCmd.Flags().StringVar(&pImportStage, "stage", "", "The stage of root nodes without stage variable")
+	importCmd.Flags().StringVarP(&pImportOutput, "output", "o", "", "Write the tree to this file instead of stdout")
+	importCmd.Flags().BoolVar(&pImportCompare, "compare", false, "Compare the imported tree with the current configuration")
+}
@@ -16,12 +16,19 @@ package cmd
 
 import (
 	"fmt"
-	// "github.com/msutter/nodetree/log"
 	tm "github.com/buger/goterm"
+	"github.com/msutter/nodetree/log"
+	"github.com/msutter/nodetree/metrics"
 	"github.com/msutter/nodetree/models"
+	"github.com/msutter/nodetree/service"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 var cfgFile string
@@ -36,7 +43,19 @@ var pAllNode bool
 var pQuiet bool
 var pSilent bool
 var pRepositories []string
+var pJunit string
+var pHtmlReport string
+var pLogLevel string
+var pLogFile string
+var pLogFormat string
+var pDebugHttp bool
+var pDebugHttpBodies bool
+var pRecord string
+var pReplay string
 var pAllRepositories bool
+var pInheritTags bool
+var pMetricsListen string
+var pMetricsFile string
 
 // This represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
@@ -71,13 +90,31 @@ func init() {
 	RootCmd.PersistentFlags().BoolVarP(&pAllNode, "all", "a", false, "Execute the command on all nodes in this stage tree")
 	RootCmd.PersistentFlags().BoolVarP(&pQuiet, "quiet", "q", false, "simple output")
 	RootCmd.PersistentFlags().BoolVarP(&pSilent, "silent", "s", false, "no output")
-	RootCmd.PersistentFlags().StringSliceVarP(&pRepositories, "repositories", "r", []string{}, "the repositories to be synced.")
+	RootCmd.PersistentFlags().StringSliceVarP(&pRepositories, "repositories", "r", []string{}, "the repositories to be synced. Accepts glob patterns ('rhel7-*') and repository sets ('@base')")
 	RootCmd.PersistentFlags().BoolVar(&pAllRepositories, "all-repositories", false, "sync all repositories")
+	RootCmd.PersistentFlags().StringVar(&pMetricsListen, "metrics-listen", "", "Expose prometheus metrics of the syncs on this address (e.g. ':9100')")
+	RootCmd.PersistentFlags().StringVar(&pMetricsFile, "metrics-file", "", "Write the prometheus metrics of the sync to this file at the end (e.g. for the node exporter textfile collector)")
+	RootCmd.PersistentFlags().BoolVar(&pInheritTags, "inherit-tags", false, "Tags are inherited by all descendants of a node")
+	RootCmd.PersistentFlags().StringVar(&pLogLevel, "log-level", "", "Log level: trace, info, warning, error or off (default 'info' with --log-file, else 'off')")
+	RootCmd.PersistentFlags().StringVar(&pLogFile, "log-file", "", "Write the logs to this file instead of stderr")
+	RootCmd.PersistentFlags().StringVar(&pLogFormat, "log-format", "text", "Log format: text or json")
+	RootCmd.PersistentFlags().BoolVar(&pDebugHttp, "debug-http", false, "Log the method, url, headers, status and latency of every pulp api call, with redacted credentials")
+	RootCmd.PersistentFlags().BoolVar(&pDebugHttpBodies, "debug-http-bodies", false, "Log the request and response bodies too (implies --debug-http)")
+	RootCmd.PersistentFlags().StringVar(&pRecord, "record", "", "Save the pulp api exchanges of each node in this directory")
+	RootCmd.PersistentFlags().StringVar(&pReplay, "replay", "", "Serve the pulp api responses saved with --record from this directory instead of contacting the nodes")
 
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
+	initLog()
+
+	if pRecord != "" && pReplay != "" {
+		ErrorExit("--record and --replay can not be combined\n")
+	}
+	models.RecordDir = pRecord
+	models.ReplayDir = pReplay
+
 	if cfgFile != "" { // enable ability to specify config file via flag
 		viper.SetConfigFile(cfgFile)
 	}
@@ -87,11 +124,37 @@ func initConfig() {
 	viper.AutomaticEnv()             // read in environment variables that match
 
 	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); (err == nil) && !pSilent {
+	err := viper.ReadInConfig()
+	if (err == nil) && !pSilent {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
 	}
+	if _, isParseError := err.(viper.ConfigParseError); isParseError {
+		ErrorExit(fmt.Sprintf("%v: %v\n", viper.ConfigFileUsed(), err))
+	}
 
-	viper.Unmarshal(&stageTree)
+	err = models.DecodeStageTreeFile(viper.ConfigFileUsed(), viper.AllSettings(), &stageTree)
+	if err != nil {
+		ErrorExit(fmt.Sprintf("%v\n", err))
+	}
+	stageTree.SetFile(viper.ConfigFileUsed())
+
+	// read the included files (e.g. stages.d/*.yaml) and instantiate the templates
+	err = stageTree.IncludeFiles(filepath.Dir(viper.ConfigFileUsed()), stageTree.Include)
+	if err != nil {
+		ErrorExit(fmt.Sprintf("%v\n", err))
+	}
+
+	if pInheritTags {
+		stageTree.InheritTags = true
+	}
+	stageTree.Init()
+	registerSecrets()
+
+	// a bad notification fails before any run
+	err = service.NewNotifier(stageTree.Notifications).Validate()
+	if err != nil {
+		ErrorExit(fmt.Sprintf("%v\n", err))
+	}
 
 }
 
@@ -132,6 +195,11 @@ func containsString(slice []string, element string) bool {
 	return !(posString(slice, element) == -1)
 }
 
+// truncateSeconds drops the fractions of seconds, for display
+func truncateSeconds(d time.Duration) time.Duration {
+	return time.Duration(int64(d)/int64(time.Second)) * time.Second
+}
+
 func ErrorExitWithUsage(ctx *cobra.Command, message string) {
 	fmt.Printf(message)
 	ctx.Usage()
@@ -143,31 +211,203 @@ func ErrorExit(message string) {
 	os.Exit(1)
 }
 
+// Start the metrics endpoint if an address is set
+func StartMetrics() {
+	if pMetricsListen == "" {
+		return
+	}
+	err := metrics.Listen(pMetricsListen, metrics.DefaultRegistry)
+	if err != nil {
+		ErrorExit(fmt.Sprintf("could not expose the metrics: %v\n", err))
+	}
+}
+
+// Write the metrics to the metrics file if one is set
+func WriteMetricsFile() {
+	if pMetricsFile == "" {
+		return
+	}
+	err := metrics.WriteFile(pMetricsFile, metrics.DefaultRegistry)
+	if err != nil && !pSilent {
+		fmt.Printf("WARNING: could not write the metrics file: %v\n", err)
+	}
+}
+
+// Send the logs to the log file or stderr, apart from the rendered output
+func initLog() {
+	models.DebugHttp = pDebugHttp || pDebugHttpBodies
+	models.DebugHttpBodies = pDebugHttpBodies
+
+	levelName := pLogLevel
+	if levelName == "" {
+		levelName = "off"
+		if pLogFile != "" {
+			levelName = "info"
+		}
+	}
+	level, err := log.ParseLevel(levelName)
+	if err != nil {
+		ErrorExit(err.Error() + "\n")
+	}
+	// the http traces are logged at info, a higher level would drop them
+	if models.DebugHttp && level > log.InfoLevel {
+		level = log.InfoLevel
+	}
+
+	var writer io.Writer = os.Stderr
+	if pLogFile != "" {
+		writer, err = os.OpenFile(pLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			ErrorExit(fmt.Sprintf("could not open the log file: %v\n", err))
+		}
+	}
+
+	err = log.Setup(level, pLogFormat, writer)
+	if err != nil {
+		ErrorExit(err.Error() + "\n")
+	}
+}
+
+// Keep the configured secrets out of the http traces
+func registerSecrets() {
+	models.RegisterSecret(viper.GetString("api_token"))
+	models.RegisterSecret(viper.GetString("ApiPasswd"))
+	for _, tagSetting := range stageTree.TagSettings {
+		models.RegisterSecret(tagSetting.ApiPasswd)
+	}
+	for _, notification := range stageTree.Notifications {
+		models.RegisterSecret(notification.Secret)
+	}
+}
+
+// Build the run of the command on the stage with the flag filters
+func NewCommandRun(command string, s *models.Stage, repositories []string) *models.Run {
+	run := models.NewRun(command, s.Name)
+	run.Repositories = repositories
+	if !pAllNode {
+		run.Fqdns = pFqdns
+		run.Tags = pTags
+	}
+	return run
+}
+
+// Record the progress events in the run
+func ObserveRun(run *models.Run, progressChannel chan models.SyncProgress) chan models.SyncProgress {
+	observedChannel := make(chan models.SyncProgress)
+	go func() {
+		for sp := range progressChannel {
+			run.Observe(sp)
+			observedChannel <- sp
+		}
+		close(observedChannel)
+	}()
+	return observedChannel
+}
+
+// Save, report and notify the ended run of the stage
+func EndRun(run *models.Run, s *models.Stage) {
+	SaveRun(run)
+	if pJunit != "" {
+		WriteJUnit(run, pJunit)
+	}
+	if pHtmlReport != "" {
+		WriteHtmlReport(run, s, pHtmlReport)
+	}
+	NotifyRun(run)
+}
+
+// Keep the ended run in the history
+func SaveRun(run *models.Run) {
+	err := run.Save()
+	if err != nil && !pSilent {
+		fmt.Printf("WARNING: could not save the run: %v\n", err)
+	}
+}
+
+// Write the JUnit XML report of the run
+func WriteJUnit(run *models.Run, file string) {
+	content, err := run.JUnit()
+	if err == nil {
+		err = ioutil.WriteFile(file, content, 0644)
+	}
+	if err != nil && !pSilent {
+		fmt.Printf("WARNING: could not write the junit report: %v\n", err)
+	}
+}
+
+// Write the HTML report of the run on the stage
+func WriteHtmlReport(run *models.Run, s *models.Stage, file string) {
+	content, err := run.HTML(s)
+	if err == nil {
+		err = ioutil.WriteFile(file, content, 0644)
+	}
+	if err != nil && !pSilent {
+		fmt.Printf("WARNING: could not write the html report: %v\n", err)
+	}
+}
+
+// Send the webhook notifications of the ended run
+func NotifyRun(run *models.Run) {
+	if len(stageTree.Notifications) == 0 {
+		return
+	}
+	notifier := service.NewNotifier(stageTree.Notifications)
+	for _, err := range notifier.Notify(run) {
+		if !pSilent {
+			fmt.Printf("WARNING: %v\n", err)
+		}
+	}
+}
+
+func RenderRepositoryList(repositories []string) {
+	fmt.Printf("\nrepositories:\n")
+	for _, repository := range repositories {
+		fmt.Printf("  - '%v'\n", repository)
+	}
+	fmt.Printf("\n")
+}
+
+// Render the failures grouped by root cause, each with the subtree it blocked
+// and a remediation hint, followed by the independent failures
 func RenderErrorSummary(s *models.Stage) {
 	titleLine := fmt.Sprintf("Found following errors:")
 	fmt.Printf("\n")
 	fmt.Printf(tm.Bold(titleLine))
 	fmt.Printf("\n")
 	fmt.Printf("\n")
-	_ = "breakpoint"
-	for _, n := range s.Nodes {
-		if n.HasError() {
-			fmt.Printf(tm.Color(tm.Bold(n.Fqdn), tm.RED))
+
+	independentTitle := false
+	for _, group := range s.FailureGroups() {
+		if len(group.Blocked) == 0 && !independentTitle {
+			fmt.Print(tm.Bold("Independent failures:"))
+			fmt.Printf("\n\n")
+			independentTitle = true
+		}
+
+		origin := group.Fqdn
+		if group.Repository != "" {
+			origin += " " + group.Repository
+		}
+		fmt.Print(tm.Color(tm.Bold(origin), tm.RED))
+		fmt.Printf("\n")
+		for _, e := range group.Errors {
+			fmt.Printf(" - ")
+			fmt.Print(tm.Color(e, tm.RED))
 			fmt.Printf("\n")
-			for _, e := range n.Errors {
-				ErrorString := fmt.Sprintf("%v", e.Error())
-				fmt.Printf(" - ")
-				fmt.Printf(tm.Color(ErrorString, tm.RED))
-				fmt.Printf("\n")
-			}
-			for k, v := range n.RepositoryError {
-				reposiroryErrorString := fmt.Sprintf("%v: ", k)
-				fmt.Printf(" - ")
-				fmt.Printf(tm.Color(reposiroryErrorString, tm.RED))
-				fmt.Printf(v.Error())
+		}
+		fmt.Printf("   hint (%v): %v\n", group.Kind, group.Hint)
+
+		if len(group.Blocked) > 0 {
+			fmt.Printf("   blocked %v node(s):\n", len(group.Blocked))
+			for _, bn := range group.Blocked {
+				fmt.Printf("   %v", strings.Repeat("--- ", bn.Depth))
+				fmt.Print(tm.Color(bn.Fqdn, tm.MAGENTA))
+				if len(bn.Repositories) > 0 {
+					fmt.Printf(" %v", strings.Join(bn.Repositories, ", "))
+				}
 				fmt.Printf("\n")
 			}
-			fmt.Printf("\n")
 		}
+		fmt.Printf("\n")
 	}
 }
@@ -0,0 +1,141 @@
+// Copyright © 2016 Marc Sutter <marc.sutter@swissflow.ch>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/msutter/nodetree/metrics"
+	"github.com/msutter/nodetree/models"
+	"github.com/msutter/nodetree/service"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"time"
+)
+
+var pListen string
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run nodetree as a service",
+	Long: `Run nodetree as a service
+
+Runs the schedules of the tree on their cron expressions.
+Only one run per stage is started at a time, a schedule
+triggering while its stage is busy is skipped.
+The runs are saved in the 'runs' directory of the state dir.
+
+Serves the HTTP API on the --listen address. Requests need the
+'api_token' setting (config file or API_TOKEN environment
+variable) as bearer token.
+
+Exposes the prometheus metrics on the --metrics-listen address.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			ErrorExitWithUsage(cmd, "no arguments allowed for serve")
+		}
+
+		if pMetricsListen == "" && pListen == "" && len(stageTree.Schedules) == 0 {
+			ErrorExitWithUsage(cmd, "serve needs schedules in the tree, a --listen or a --metrics-listen address")
+		}
+
+		runner := service.NewRunner(&stageTree)
+		scheduler, err := service.NewScheduler(runner)
+		if err != nil {
+			ErrorExit(err.Error())
+		}
+
+		if pMetricsListen != "" {
+			StartMetrics()
+			runner.ProgressHandlers = append(runner.ProgressHandlers, func(run *models.Run, sp models.SyncProgress) {
+				metrics.DefaultRegistry.Observe(run.Stage, sp)
+			})
+			if !pSilent {
+				fmt.Printf("serving metrics on %v\n", pMetricsListen)
+			}
+		}
+
+		if len(stageTree.Notifications) > 0 {
+			notifier := service.NewNotifier(stageTree.Notifications)
+			// retried deliveries must not keep the stage busy
+			runner.FinishHandlers = append(runner.FinishHandlers, func(run *models.Run) {
+				go func() {
+					for _, err := range notifier.Notify(run) {
+						if !pSilent {
+							fmt.Printf("WARNING: %v\n", err)
+						}
+					}
+				}()
+			})
+		}
+
+		if pListen != "" {
+			token := viper.GetString("api_token")
+			if token == "" {
+				ErrorExit("the api needs an 'api_token' setting\n")
+			}
+			err = service.Listen(pListen, service.NewAPI(runner, token))
+			if err != nil {
+				ErrorExit(fmt.Sprintf("could not serve the api: %v\n", err))
+			}
+			if !pSilent {
+				fmt.Printf("serving api on %v\n", pListen)
+			}
+		}
+
+		if !pSilent {
+			runner.FinishHandlers = append(runner.FinishHandlers, RenderRunResult)
+			scheduler.OnTrigger = RenderScheduleTrigger
+			now := time.Now()
+			for _, schedule := range stageTree.Schedules {
+				fmt.Printf("schedule '%v': %v of stage '%v' on '%v', next at %v\n",
+					schedule.Name,
+					schedule.GetCommand(),
+					schedule.Stage,
+					schedule.Cron,
+					scheduler.Next(schedule.Name, now).Format(time.RFC3339))
+			}
+		}
+
+		scheduler.Run()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&pListen, "listen", "", "Serve the HTTP API on this address (e.g. ':8080')")
+}
+
+func RenderScheduleTrigger(schedule *models.Schedule, run *models.Run, err error) {
+	if err != nil {
+		fmt.Printf("%v schedule '%v' skipped: %v\n", time.Now().Format(time.RFC3339), schedule.Name, err)
+		return
+	}
+	fmt.Printf("%v schedule '%v' started run %v\n", run.Start.Format(time.RFC3339), schedule.Name, run.Id)
+}
+
+func RenderRunResult(run *models.Run) {
+	fmt.Printf("%v run %v of stage '%v' %v after %v",
+		run.End.Format(time.RFC3339),
+		run.Id,
+		run.Stage,
+		run.State,
+		truncateSeconds(run.Duration()))
+	if run.Message != "" {
+		fmt.Printf(": %v", run.Message)
+	}
+	fmt.Printf("\n")
+}
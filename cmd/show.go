@@ -16,9 +16,16 @@ package cmd
 
 import (
 	"fmt"
+	tm "github.com/buger/goterm"
+	"github.com/msutter/nodetree/models"
 	"github.com/spf13/cobra"
+	"time"
 )
 
+var pShowFormat string
+var pShowErrors bool
+var pShowLive bool
+
 // showCmd represents the show command
 var showCmd = &cobra.Command{
 	Use:   "show [stage name]",
@@ -38,13 +45,40 @@ Filters can be set on Fqdns and tags.`,
 
 		currentStage := stageTree.GetStageByName(args[0])
 
+		var stage *models.Stage
+
 		if pAllNode {
-			currentStage.Show()
+			stage = currentStage
 		} else {
-			filteredStage := currentStage.Filter(pFqdns, pTags)
-			filteredStage.Show()
+			stage = currentStage.Filter(pFqdns, pTags)
+		}
+
+		// color by the error state of the last check or sync run of each node
+		var nodeStates map[string]*models.NodeState
+		if pShowErrors {
+			var err error
+			nodeStates, err = models.LatestNodeStates(stage.Name)
+			if err != nil {
+				ErrorExit(err.Error() + "\n")
+			}
+		}
+
+		switch pShowFormat {
+		case "text":
+			if pShowLive {
+				stage.UpdateLive()
+				RenderLiveTree(stage)
+			} else {
+				stage.Show()
+			}
+			fmt.Printf("\n")
+		case "dot":
+			fmt.Print(stage.Dot(nodeStates))
+		case "mermaid":
+			fmt.Print(stage.Mermaid(nodeStates))
+		default:
+			ErrorExitWithUsage(cmd, fmt.Sprintf("unknown format '%v'\n", pShowFormat))
 		}
-		fmt.Printf("\n")
 
 	},
 }
@@ -61,4 +95,30 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// showCmd.Flags().StringSlice("fqdns", []string{}, "Filter on Fqdns")
+	showCmd.Flags().StringVar(&pShowFormat, "format", "text", "Output format: text, dot or mermaid")
+	showCmd.Flags().BoolVar(&pShowLive, "live", false, "Query every node and show reachability, api latency, repository count and feed errors")
+	showCmd.Flags().BoolVar(&pShowErrors, "errors", false, "Color the nodes by the error state of the last check or sync run (dot and mermaid)")
+}
+
+// tree view annotated with the live node information
+func RenderLiveTree(s *models.Stage) {
+	s.NodeTreeWalker(s.PulpRootNode, func(n *models.Node) {
+		if !n.Reachable {
+			line := fmt.Sprintf("%v unreachable: %v", n.Fqdn, n.Errors[0])
+			fmt.Print(tm.Color(n.GetTreeRaw(line), tm.RED))
+			fmt.Printf("\n")
+			return
+		}
+
+		line := fmt.Sprintf("%v %v %vms %v repositories",
+			n.Fqdn,
+			tm.Color("reachable", tm.GREEN),
+			n.ApiLatency.Nanoseconds()/int64(time.Millisecond),
+			len(n.Repositories))
+		if len(n.RepositoryError) > 0 {
+			line = fmt.Sprintf("%v %v", line, tm.Color("[feed error]", tm.YELLOW))
+		}
+		fmt.Print(n.GetTreeRaw(line))
+		fmt.Printf("\n")
+	})
 }
@@ -0,0 +1,178 @@
+// Copyright © 2016 Marc Sutter <marc.sutter@swissflow.ch>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/msutter/nodetree/models"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var pSimulationSpeed float64
+var pSimulationSeed int64
+var pSimulationBandwidth int
+var pSimulationFailureProbability float64
+var pSimulationFail []string
+
+// the last runs the repository sizes are taken from
+const simulationHistoryRuns = 100
+
+// simulateCmd represents the simulate command
+var simulateCmd = &cobra.Command{
+	Use:   "simulate [stage name]",
+	Short: "Estimate the sync duration of a stage and rehearse failures",
+	Long: `Estimate the sync duration of a stage and rehearse failures
+
+Runs the sync of the stage against a simulated pulp backend. The
+bandwidths, repository sizes and failure probabilities come from the
+'simulation' section of the config, the sizes also from the history.
+Reports the estimated duration, the critical path and the nodes
+blocked by failing nodes. No pulp server is contacted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			ErrorExitWithUsage(cmd, "simulate needs a name for the stage")
+		}
+
+		if len(pRepositories) == 0 {
+			ErrorExitWithUsage(cmd, "simulate needs a repository name")
+		}
+
+		if pSimulationSpeed <= 0 {
+			ErrorExitWithUsage(cmd, "the speed must be positive")
+		}
+
+		currentStage := stageTree.GetStageByName(args[0])
+		if currentStage == nil {
+			ErrorExit(fmt.Sprintf("stage '%v' not found\n", args[0]))
+		}
+
+		settings := stageTree.Simulation
+		if cmd.Flags().Changed("bandwidth") {
+			settings.Bandwidth = pSimulationBandwidth
+		}
+		if cmd.Flags().Changed("failure-probability") {
+			settings.FailureProbability = pSimulationFailureProbability
+		}
+
+		simulation := models.NewSimulation(settings, pSimulationSpeed, pSimulationSeed)
+		simulation.FailingNodes = pSimulationFail
+		runs, err := models.LoadRuns(simulationHistoryRuns, nil)
+		if err != nil {
+			ErrorExit(err.Error())
+		}
+		simulation.SizesFromHistory(runs)
+		simulation.AddKnownRepositories()
+
+		// all the api clients talk to the simulation from now on
+		models.ActiveSimulation = simulation
+		// the timeline is taken from the run, delays are multiplied by the speed
+		models.PollInterval = time.Millisecond
+		models.WalkDelay = 0
+
+		// the stage of the config keeps its node states
+		stage := currentStage.Copy()
+		if !pAllNode && (len(pFqdns) > 0 || len(pTags) > 0) {
+			stage = stage.Filter(pFqdns, pTags)
+		}
+
+		stage.Policy = NewCommandFailurePolicy(cmd)
+
+		repositories, err := stage.ExpandRepositories(pRepositories)
+		if err != nil {
+			ErrorExit(err.Error())
+		}
+		simulation.AddRepositories(repositories)
+		if !pSilent {
+			RenderRepositoryList(repositories)
+		}
+
+		// the run is observed for the report, but not kept in the history
+		run := NewCommandRun("sync", stage, repositories)
+		progressChannel := make(chan models.SyncProgress)
+		renderChannel := ObserveRun(run, progressChannel)
+
+		var renderWg sync.WaitGroup
+		renderWg.Add(1)
+		go RenderSilentView(renderChannel, &renderWg)
+		stage.Sync(repositories, progressChannel)
+		renderWg.Wait()
+		run.Finish(stage)
+
+		RenderSimulationReport(simulation.Report(run, stage))
+		if reason := stage.Policy.Reason(); reason != "" {
+			fmt.Printf("sync stopped early, %v\n\n", reason)
+		}
+		if stage.HasError() && !pSilent {
+			RenderErrorSummary(stage)
+		}
+	},
+}
+
+func init() {
+	pulpCmd.AddCommand(simulateCmd)
+
+	simulateCmd.Flags().Float64Var(&pSimulationSpeed, "speed", 1000, "How many times faster than the estimated time the simulation runs")
+	simulateCmd.Flags().Int64Var(&pSimulationSeed, "seed", 1, "Seed of the random failures")
+	simulateCmd.Flags().IntVar(&pSimulationBandwidth, "bandwidth", 0, "Bandwidth of all nodes in bytes per second (default from the config, else 10 MiB/s)")
+	simulateCmd.Flags().Float64Var(&pSimulationFailureProbability, "failure-probability", 0, "Probability of a repository sync to fail, from 0 to 1 (default from the config)")
+	AddFailurePolicyFlags(simulateCmd)
+	simulateCmd.Flags().StringSliceVar(&pSimulationFail, "fail", []string{}, "Let all syncs of this node fail. You can define multiple nodes by repeating the flag")
+}
+
+func simulatedDuration(d time.Duration) string {
+	return truncateSeconds(d).String()
+}
+
+// Render the estimated timeline, the critical path and the failure impact
+func RenderSimulationReport(report *models.SimulationReport) {
+	fmt.Printf("\nestimated sync duration: %v\n\n", simulatedDuration(report.Total))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "NODE\tSTART\tEND\tDURATION\tFAILED\tSKIPPED\n")
+	for _, sn := range report.Nodes {
+		fmt.Fprintf(w, "%v%v\t%v\t%v\t%v\t%v\t%v\n",
+			strings.Repeat("--- ", sn.Depth),
+			sn.Fqdn,
+			simulatedDuration(sn.Start),
+			simulatedDuration(sn.End),
+			simulatedDuration(sn.End-sn.Start),
+			strings.Join(sn.Failed, ","),
+			strings.Join(sn.Skipped, ","))
+	}
+	w.Flush()
+
+	fmt.Printf("\ncritical path:\n")
+	for _, sn := range report.CriticalPath {
+		fmt.Printf("  %v (%v - %v)\n", sn.Fqdn, simulatedDuration(sn.Start), simulatedDuration(sn.End))
+	}
+
+	fmt.Printf("\nfailure impact:\n")
+	for _, sn := range report.Nodes {
+		if len(sn.Descendants) == 0 {
+			continue
+		}
+		line := fmt.Sprintf("  %v would block %v node(s): %v", sn.Fqdn, len(sn.Descendants), strings.Join(sn.Descendants, ", "))
+		if len(sn.Failed) > 0 {
+			line += fmt.Sprintf(" (failed on %v)", strings.Join(sn.Failed, ", "))
+		}
+		fmt.Println(line)
+	}
+	fmt.Println("")
+}
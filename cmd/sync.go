@@ -15,14 +15,27 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	tm "github.com/buger/goterm"
+	"github.com/msutter/nodetree/metrics"
 	"github.com/msutter/nodetree/models"
 	"github.com/spf13/cobra"
+	"io/ioutil"
 	"os"
 	"sync"
+	"text/tabwriter"
+	"time"
 )
 
+var pPreflight bool
+var pPreflightMode string
+var pSummary string
+var pSummaryFile string
+var pOnFailure string
+var pMaxFailures int
+var pCancelTasks bool
+
 // syncCmd represents the sync command
 var syncCmd = &cobra.Command{
 	Use:   "sync [stage name]",
@@ -39,8 +52,25 @@ Filters can be set on Fqdns and tags.`,
 			ErrorExitWithUsage(cmd, "sync needs a repository name")
 		}
 
+		if pSummary != "text" && pSummary != "json" && pSummary != "none" {
+			ErrorExitWithUsage(cmd, fmt.Sprintf("unknown summary format '%v'", pSummary))
+		}
+
 		currentStage := stageTree.GetStageByName(args[0])
 
+		// expand repository sets and patterns
+		if !pAllRepositories {
+			repositories, err := currentStage.ExpandRepositories(pRepositories)
+			if err != nil {
+				ErrorExit(err.Error())
+			}
+			pRepositories = repositories
+
+			if !pSilent {
+				RenderRepositoryList(pRepositories)
+			}
+		}
+
 		// check for flags
 		if len(pFqdns) == 0 && len(pTags) == 0 && !pAllNode {
 			fmt.Printf("\nWARNING: This will sync the complete tree for the '%v' stage!\n", args[0])
@@ -65,20 +95,40 @@ Filters can be set on Fqdns and tags.`,
 			stage = currentStage.Filter(pFqdns, pTags)
 		}
 
+		stage.Policy = NewCommandFailurePolicy(cmd)
+
+		// the stage settings are the defaults, the flags override them
+		if cmd.Flags().Changed("preflight") {
+			stage.Preflight = pPreflight
+		}
+		if cmd.Flags().Changed("preflight-mode") {
+			stage.PreflightMode = pPreflightMode
+		}
+		run := NewCommandRun("sync", stage, pRepositories)
+
+		RunPreflight(run, stage, pRepositories)
+
 		// Create a progress channel
 		progressChannel := make(chan models.SyncProgress)
 
+		// the run and the metrics are derived from the progress events
+		renderChannel := ObserveRun(run, progressChannel)
+		if pMetricsListen != "" || pMetricsFile != "" {
+			StartMetrics()
+			renderChannel = metrics.DefaultRegistry.Tee(stage.Name, renderChannel)
+		}
+
 		var renderWg sync.WaitGroup
 		renderWg.Add(1)
 
 		switch {
 		case pSilent:
-			go RenderSilentView(progressChannel, &renderWg)
+			go RenderSilentView(renderChannel, &renderWg)
 		case pQuiet:
-			go RenderQuietView(progressChannel, &renderWg)
+			go RenderQuietView(renderChannel, &renderWg)
 		default:
-			go RenderQuietView(progressChannel, &renderWg)
-			// go RenderProgressView(stage, progressChannel, &renderWg)
+			go RenderQuietView(renderChannel, &renderWg)
+			// go RenderProgressView(stage, renderChannel, &renderWg)
 		}
 
 		if pAllRepositories {
@@ -89,6 +139,29 @@ Filters can be set on Fqdns and tags.`,
 
 		renderWg.Wait()
 
+		run.Finish(stage)
+		EndRun(run, stage)
+		WriteMetricsFile()
+
+		if reason := stage.Policy.Reason(); reason != "" && !pSilent {
+			fmt.Printf("\nsync stopped early, %v\n", reason)
+		}
+
+		if pSummaryFile != "" {
+			err := WriteRunSummaryJson(run.Summary(stage), pSummaryFile)
+			if err != nil && !pSilent {
+				fmt.Printf("WARNING: could not write the summary file: %v\n", err)
+			}
+		}
+		switch pSummary {
+		case "json":
+			RenderRunSummaryJson(run.Summary(stage))
+		case "text":
+			if !pSilent {
+				RenderRunSummary(run.Summary(stage))
+			}
+		}
+
 		if stage.HasError() {
 			switch {
 			case pSilent:
@@ -105,6 +178,14 @@ Filters can be set on Fqdns and tags.`,
 func init() {
 	pulpCmd.AddCommand(syncCmd)
 
+	syncCmd.Flags().BoolVar(&pPreflight, "preflight", false, "Check authentication, workers, repositories and feeds of all nodes before syncing (default per stage: 'preflight: true')")
+	syncCmd.Flags().StringVar(&pJunit, "junit", "", "Write a JUnit XML report of the sync to this file")
+	syncCmd.Flags().StringVar(&pHtmlReport, "html-report", "", "Write a self-contained HTML report of the sync to this file")
+	syncCmd.Flags().StringVar(&pPreflightMode, "preflight-mode", "abort", "On preflight failures: 'abort' the sync or 'skip' the failing subtrees (default per stage: 'preflight_mode')")
+	AddFailurePolicyFlags(syncCmd)
+	syncCmd.Flags().StringVar(&pSummary, "summary", "text", "Summary of the repositories on each node at the end of the sync: 'text', 'json' or 'none'")
+	syncCmd.Flags().StringVar(&pSummaryFile, "summary-file", "", "Write the summary as JSON to this file at the end of the sync")
+
 	// Here you will define your flags and configuration settings.
 
 	// Cobra supports Persistent Flags which will work for this command
@@ -116,11 +197,39 @@ func init() {
 	// syncCmd.Flags().StringSlice("fqdns", []string{}, "Filter on Fqdns")
 }
 
+// The interval between the progress lines of a running sync
+const progressRenderInterval = 10 * time.Second
+
+// Format the percentage, throughput and remaining times of a progress event
+func RenderProgressDetails(sp models.SyncProgress) (details string) {
+	switch sp.State {
+	case "running":
+		if sp.SizeTotal > 0 {
+			details += fmt.Sprintf(" %v%%", sp.SizePercent())
+		}
+		if sp.Throughput > 0 {
+			details += fmt.Sprintf(" %v/s", models.FormatBytes(int(sp.Throughput)))
+		}
+		if sp.Eta > 0 {
+			details += fmt.Sprintf(" eta %v", truncateSeconds(sp.Eta))
+		}
+	case "finished":
+		if sp.Throughput > 0 {
+			details += fmt.Sprintf(" %v/s", models.FormatBytes(int(sp.Throughput)))
+		}
+	}
+	if sp.StageEta > 0 {
+		details += fmt.Sprintf(" (stage eta %v)", truncateSeconds(sp.StageEta))
+	}
+	return
+}
+
 // simple view. No in place updates
 func RenderQuietView(progressChannel chan models.SyncProgress, wg *sync.WaitGroup) {
 	depthChar := "--- "
 	defer wg.Done()
 	syncStates := make(map[string]map[string]string)
+	lastRendered := make(map[string]time.Time)
 	for sp := range progressChannel {
 		if _, exists := syncStates[sp.Node.Fqdn]; !exists {
 			syncStates[sp.Node.Fqdn] = make(map[string]string)
@@ -133,6 +242,13 @@ func RenderQuietView(progressChannel chan models.SyncProgress, wg *sync.WaitGrou
 			line := fmt.Sprintf("%v %v %v", sp.Node.Fqdn, sp.Repository, sp.State)
 			tm.Printf(tm.Color(tm.Bold(line), tm.MAGENTA))
 			tm.Flush()
+		case "not applicable":
+			for i := 0; i < sp.Node.Depth; i++ {
+				fmt.Printf(depthChar)
+			}
+			line := fmt.Sprintf("%v %v %v", sp.Node.Fqdn, sp.Repository, sp.State)
+			tm.Printf(tm.Color(line, tm.CYAN))
+			tm.Flush()
 		case "error":
 			for i := 0; i < sp.Node.Depth; i++ {
 				fmt.Printf(depthChar)
@@ -141,27 +257,78 @@ func RenderQuietView(progressChannel chan models.SyncProgress, wg *sync.WaitGrou
 			tm.Printf(tm.Color(tm.Bold(line), tm.RED))
 			tm.Flush()
 		case "running":
-			// only output state changes
-			if syncStates[sp.Node.Fqdn][sp.Repository] != sp.State {
+			// only output state changes, and the progress from time to time
+			key := sp.Node.Fqdn + "/" + sp.Repository
+			if syncStates[sp.Node.Fqdn][sp.Repository] != sp.State || time.Since(lastRendered[key]) >= progressRenderInterval {
 				for i := 0; i < sp.Node.Depth; i++ {
 					fmt.Printf(depthChar)
 				}
-				line := fmt.Sprintf("%v %v %v", sp.Node.Fqdn, sp.Repository, sp.State)
-				tm.Printf(tm.Color(line, tm.BLUE))
+				line := fmt.Sprintf("%v %v %v%v", sp.Node.Fqdn, sp.Repository, sp.State, RenderProgressDetails(sp))
+				tm.Print(tm.Color(line, tm.BLUE))
 				tm.Flush()
+				lastRendered[key] = time.Now()
 			}
 			syncStates[sp.Node.Fqdn][sp.Repository] = sp.State
 		case "finished":
 			for i := 0; i < sp.Node.Depth; i++ {
 				fmt.Printf(depthChar)
 			}
-			line := fmt.Sprintf("%v %v %v", sp.Node.Fqdn, sp.Repository, sp.State)
-			tm.Printf(tm.Color(tm.Bold(line), tm.GREEN))
+			line := fmt.Sprintf("%v %v %v%v", sp.Node.Fqdn, sp.Repository, sp.State, RenderProgressDetails(sp))
+			tm.Print(tm.Color(tm.Bold(line), tm.GREEN))
 			tm.Flush()
 		}
 	}
 }
 
+// Add the flags of the failure policy to the command
+func AddFailurePolicyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&pOnFailure, "on-failure", "continue", "On failed syncs: 'continue' with the independent syncs, 'fail-fast' to start no more syncs or 'stop-level' to start no syncs below the failing level")
+	cmd.Flags().IntVar(&pMaxFailures, "max-failures", 0, "Number of failed syncs triggering the --on-failure policy, 0 for the default (1, unlimited for 'continue')")
+	cmd.Flags().BoolVar(&pCancelTasks, "cancel-tasks", false, "Cancel the running tasks of the nodes stopped by the failure policy")
+}
+
+// Get the failure policy of the flags
+func NewCommandFailurePolicy(cmd *cobra.Command) *models.FailurePolicy {
+	policy, err := models.NewFailurePolicy(pOnFailure, pMaxFailures, pCancelTasks)
+	if err != nil {
+		ErrorExitWithUsage(cmd, err.Error())
+	}
+	return policy
+}
+
+// Run the preflight checks if the stage enables them. Aborts the sync on
+// failures, or lets the sync skip the failing subtrees.
+func RunPreflight(run *models.Run, stage *models.Stage, repositories []string) {
+	if stage.Preflight && !pSilent {
+		fmt.Printf("running preflight checks on stage '%v'\n", stage.Name)
+	}
+
+	failed, err := stage.RunPreflight(repositories)
+	if err != nil && len(failed) == 0 {
+		ErrorExit(err.Error() + "\n")
+	}
+	if err != nil {
+		if !pSilent {
+			RenderErrorSummary(stage)
+		}
+		run.Finish(stage)
+		run.Abort(err)
+		EndRun(run, stage)
+		ErrorExit(err.Error() + "\n")
+	}
+	if len(failed) == 0 {
+		return
+	}
+
+	if !pSilent {
+		fmt.Printf("preflight failed, skipping the subtrees of:\n")
+		for _, n := range failed {
+			fmt.Printf("  - %v\n", n.Fqdn)
+		}
+		fmt.Printf("\n")
+	}
+}
+
 // silent view
 func RenderSilentView(progressChannel chan models.SyncProgress, wg *sync.WaitGroup) {
 	defer wg.Done()
@@ -171,3 +338,81 @@ func RenderSilentView(progressChannel chan models.SyncProgress, wg *sync.WaitGro
 		_ = sp
 	}
 }
+
+func summaryDuration(seconds float64) string {
+	return truncateSeconds(time.Duration(seconds * float64(time.Second))).String()
+}
+
+// Render the state, duration, items and size of each repository on each node,
+// with the totals per node and for the stage
+func RenderRunSummary(summary *models.RunSummary) {
+	fmt.Printf("\nsummary of %v run %v on stage '%v': %v after %v\n\n",
+		summary.Command,
+		summary.Id,
+		summary.Stage,
+		summary.State,
+		summaryDuration(summary.Totals.DurationSeconds))
+	if summary.Message != "" {
+		fmt.Printf("%v\n\n", summary.Message)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "NODE\tREPOSITORY\tSTATE\tDURATION\tITEMS\tSIZE\n")
+	for _, ns := range summary.Nodes {
+		fqdn := ns.Fqdn
+		for _, row := range ns.Repositories {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n",
+				fqdn,
+				row.Repository,
+				row.State,
+				summaryDuration(row.DurationSeconds),
+				row.ItemsTotal,
+				models.FormatBytes(row.SizeTotal))
+			fqdn = ""
+		}
+		fmt.Fprintf(w, "\ttotal\t%v\t%v\t%v\t%v\n",
+			renderSummaryStates(ns.Totals),
+			summaryDuration(ns.Totals.DurationSeconds),
+			ns.Totals.ItemsTotal,
+			models.FormatBytes(ns.Totals.SizeTotal))
+	}
+	fmt.Fprintf(w, "stage\ttotal\t%v\t%v\t%v\t%v\n",
+		renderSummaryStates(summary.Totals),
+		summaryDuration(summary.Totals.DurationSeconds),
+		summary.Totals.ItemsTotal,
+		models.FormatBytes(summary.Totals.SizeTotal))
+	w.Flush()
+	fmt.Println("")
+}
+
+func renderSummaryStates(totals models.SummaryTotals) string {
+	states := fmt.Sprintf("%v ok", totals.Finished)
+	if totals.Failed > 0 {
+		states += fmt.Sprintf(", %v failed", totals.Failed)
+	}
+	if totals.Skipped > 0 {
+		states += fmt.Sprintf(", %v skipped", totals.Skipped)
+	}
+	if totals.NotApplicable > 0 {
+		states += fmt.Sprintf(", %v n/a", totals.NotApplicable)
+	}
+	return states
+}
+
+// Render the summary as indented JSON
+func RenderRunSummaryJson(summary *models.RunSummary) {
+	content, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		ErrorExit(err.Error())
+	}
+	fmt.Println(string(content))
+}
+
+// Write the summary as JSON to the file, apart from the output of the sync
+func WriteRunSummaryJson(summary *models.RunSummary, file string) error {
+	content, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(content, '\n'), 0644)
+}
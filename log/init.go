@@ -1,10 +1,45 @@
 package log
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+type Level int
+
+const (
+	TraceLevel Level = iota
+	InfoLevel
+	WarningLevel
+	ErrorLevel
+	OffLevel
+)
+
+var levelNames = []string{"trace", "info", "warning", "error", "off"}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// Parse a level name: trace, info, warning, error or off
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.ToLower(name) == levelName {
+			return Level(i), nil
+		}
+	}
+	errorMsg := fmt.Sprintf("unknown log level '%v'", name)
+	return OffLevel, errors.New(errorMsg)
+}
+
 var (
 	Trace   *log.Logger
 	Info    *log.Logger
@@ -12,6 +47,17 @@ var (
 	Error   *log.Logger
 )
 
+var (
+	mu     sync.Mutex
+	out    io.Writer = ioutil.Discard
+	level            = OffLevel
+	format           = "text"
+)
+
+func init() {
+	Init(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+}
+
 func Init(
 
 	traceHandle io.Writer,
@@ -35,3 +81,114 @@ func Init(
 		"ERROR: ",
 		log.Ldate|log.Ltime)
 }
+
+// Write the logs from the given level on in the text or json format
+func Setup(logLevel Level, logFormat string, writer io.Writer) (err error) {
+	if logFormat != "text" && logFormat != "json" {
+		errorMsg := fmt.Sprintf("unknown log format '%v'", logFormat)
+		return errors.New(errorMsg)
+	}
+
+	mu.Lock()
+	out = writer
+	level = logLevel
+	format = logFormat
+	mu.Unlock()
+
+	// the plain loggers write through the formatter, without fields
+	Trace = log.New(levelWriter(TraceLevel), "", 0)
+	Info = log.New(levelWriter(InfoLevel), "", 0)
+	Warning = log.New(levelWriter(WarningLevel), "", 0)
+	Error = log.New(levelWriter(ErrorLevel), "", 0)
+	return
+}
+
+type levelWriter Level
+
+func (lw levelWriter) Write(p []byte) (int, error) {
+	write(Level(lw), strings.TrimSuffix(string(p), "\n"), nil)
+	return len(p), nil
+}
+
+// Is the level logged?
+func Enabled(l Level) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return l >= level && level != OffLevel
+}
+
+type Fields map[string]interface{}
+
+// A log entry with fields
+type Entry struct {
+	fields Fields
+}
+
+func WithFields(fields Fields) *Entry {
+	return &Entry{fields: fields}
+}
+
+// Get a copy of the entry with the additional field
+func (e *Entry) With(key string, value interface{}) *Entry {
+	fields := Fields{}
+	for k, v := range e.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return &Entry{fields: fields}
+}
+
+func (e *Entry) Tracef(msgFormat string, args ...interface{}) {
+	write(TraceLevel, fmt.Sprintf(msgFormat, args...), e.fields)
+}
+
+func (e *Entry) Infof(msgFormat string, args ...interface{}) {
+	write(InfoLevel, fmt.Sprintf(msgFormat, args...), e.fields)
+}
+
+func (e *Entry) Warningf(msgFormat string, args ...interface{}) {
+	write(WarningLevel, fmt.Sprintf(msgFormat, args...), e.fields)
+}
+
+func (e *Entry) Errorf(msgFormat string, args ...interface{}) {
+	write(ErrorLevel, fmt.Sprintf(msgFormat, args...), e.fields)
+}
+
+func write(l Level, msg string, fields Fields) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if l < level || level == OffLevel {
+		return
+	}
+	now := time.Now().Format(time.RFC3339)
+
+	keys := []string{}
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var line string
+	if format == "json" {
+		record := map[string]interface{}{}
+		for key, value := range fields {
+			if err, isError := value.(error); isError {
+				value = err.Error()
+			}
+			record[key] = value
+		}
+		record["time"] = now
+		record["level"] = l.String()
+		record["msg"] = msg
+		content, _ := json.Marshal(record)
+		line = string(content)
+	} else {
+		line = fmt.Sprintf("%v %-7v %v", now, strings.ToUpper(l.String()), msg)
+		for _, key := range keys {
+			line += fmt.Sprintf(" %v=%q", key, fmt.Sprintf("%v", fields[key]))
+		}
+	}
+
+	fmt.Fprintln(out, line)
+}
@@ -0,0 +1,245 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/msutter/nodetree/models"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics of the syncs of a repository on a node
+type repositoryMetrics struct {
+	labels      string
+	start       time.Time
+	duration    float64
+	lastSuccess float64
+	bytes       float64
+	items       float64
+	failures    float64
+	throughput  float64
+	eta         float64
+	lastReport  models.SyncProgress
+}
+
+// Metrics of the api calls to a node
+type nodeMetrics struct {
+	labels    string
+	apiErrors float64
+}
+
+// Registry of the metrics derived from the sync progress events,
+// exposed in the prometheus text format.
+type Registry struct {
+	mu           sync.Mutex
+	repositories map[string]*repositoryMetrics
+	nodes        map[string]*nodeMetrics
+	// estimated remaining seconds of the syncs by stage
+	stageEtas map[string]float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		repositories: make(map[string]*repositoryMetrics),
+		nodes:        make(map[string]*nodeMetrics),
+		stageEtas:    make(map[string]float64),
+	}
+}
+
+// The registry used by the commands
+var DefaultRegistry = NewRegistry()
+
+// Update the metrics with a sync progress event of the given stage
+func (r *Registry) Observe(stage string, sp models.SyncProgress) {
+	// repositories absent on purpose have no metrics
+	if sp.State == "not applicable" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tags := strings.Join(sp.Node.Tags, ",")
+	nodeKey := stage + "/" + sp.Node.Fqdn
+	nm, exists := r.nodes[nodeKey]
+	if !exists {
+		nm = &nodeMetrics{
+			labels: formatLabels("stage", stage, "fqdn", sp.Node.Fqdn, "tags", tags),
+		}
+		r.nodes[nodeKey] = nm
+	}
+
+	key := nodeKey + "/" + sp.Repository
+	rm, exists := r.repositories[key]
+	if !exists {
+		rm = &repositoryMetrics{
+			labels: formatLabels("stage", stage, "fqdn", sp.Node.Fqdn, "repository", sp.Repository, "tags", tags),
+		}
+		r.repositories[key] = rm
+	}
+
+	r.stageEtas[stage] = sp.StageEta.Seconds()
+	rm.throughput = sp.Throughput
+	rm.eta = sp.Eta.Seconds()
+
+	now := time.Now()
+	switch sp.State {
+	case "running":
+		if rm.start.IsZero() {
+			rm.start = now
+		}
+		rm.lastReport = sp
+	case "finished":
+		if !rm.start.IsZero() {
+			rm.duration = now.Sub(rm.start).Seconds()
+		}
+		rm.lastSuccess = float64(now.Unix())
+		// the finished report carries the final sizes, if the task had a result
+		if sp.SizeTotal > 0 || sp.ItemsTotal > 0 {
+			rm.lastReport = sp
+		}
+		rm.bytes += float64(rm.lastReport.SizeTotal)
+		rm.items += float64(rm.lastReport.ItemsTotal)
+		rm.start = time.Time{}
+		rm.lastReport = models.SyncProgress{}
+	case "error":
+		if !rm.start.IsZero() {
+			rm.duration = now.Sub(rm.start).Seconds()
+		}
+		rm.failures++
+		rm.start = time.Time{}
+		if sp.Error != nil && models.IsApiError(sp.Error) {
+			nm.apiErrors++
+		}
+	}
+}
+
+// Observe the events of the progress channel and forward them to the returned channel
+func (r *Registry) Tee(stage string, progressChannel chan models.SyncProgress) chan models.SyncProgress {
+	out := make(chan models.SyncProgress)
+	go func() {
+		defer close(out)
+		for sp := range progressChannel {
+			r.Observe(stage, sp)
+			out <- sp
+		}
+	}()
+	return out
+}
+
+// Write the metrics in the prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(r.Bytes())
+}
+
+// Get the metrics in the prometheus text format
+func (r *Registry) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var buffer bytes.Buffer
+	r.writeRepositoryMetric(&buffer, "nodetree_sync_duration_seconds", "gauge",
+		"Duration of the last sync of the repository.",
+		func(rm *repositoryMetrics) float64 { return rm.duration })
+	r.writeRepositoryMetric(&buffer, "nodetree_sync_last_success_timestamp_seconds", "gauge",
+		"Unix time of the last successful sync of the repository.",
+		func(rm *repositoryMetrics) float64 { return rm.lastSuccess })
+	r.writeRepositoryMetric(&buffer, "nodetree_sync_bytes_total", "counter",
+		"Bytes transferred by the successful syncs of the repository.",
+		func(rm *repositoryMetrics) float64 { return rm.bytes })
+	r.writeRepositoryMetric(&buffer, "nodetree_sync_items_total", "counter",
+		"Items transferred by the successful syncs of the repository.",
+		func(rm *repositoryMetrics) float64 { return rm.items })
+	r.writeRepositoryMetric(&buffer, "nodetree_sync_failures_total", "counter",
+		"Failed syncs of the repository.",
+		func(rm *repositoryMetrics) float64 { return rm.failures })
+	r.writeRepositoryMetric(&buffer, "nodetree_sync_throughput_bytes_per_second", "gauge",
+		"Throughput of the running or last sync of the repository.",
+		func(rm *repositoryMetrics) float64 { return rm.throughput })
+	r.writeRepositoryMetric(&buffer, "nodetree_sync_eta_seconds", "gauge",
+		"Estimated remaining time of the running sync of the repository, 0 if unknown.",
+		func(rm *repositoryMetrics) float64 { return rm.eta })
+
+	writeHeader(&buffer, "nodetree_stage_eta_seconds", "gauge", "Estimated remaining time of the sync of the stage, 0 if unknown.")
+	stages := []string{}
+	for stage := range r.stageEtas {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	for _, stage := range stages {
+		buffer.WriteString(fmt.Sprintf("nodetree_stage_eta_seconds{%v} %v\n", formatLabels("stage", stage), r.stageEtas[stage]))
+	}
+
+	writeHeader(&buffer, "nodetree_api_errors_total", "counter", "Failed pulp api calls to the node.")
+	for _, key := range sortedKeys(r.nodes) {
+		nm := r.nodes[key]
+		buffer.WriteString(fmt.Sprintf("nodetree_api_errors_total{%v} %v\n", nm.labels, nm.apiErrors))
+	}
+	return buffer.Bytes()
+}
+
+func (r *Registry) writeRepositoryMetric(buffer *bytes.Buffer, name string, kind string, help string, value func(*repositoryMetrics) float64) {
+	writeHeader(buffer, name, kind, help)
+	keys := []string{}
+	for key := range r.repositories {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		rm := r.repositories[key]
+		buffer.WriteString(fmt.Sprintf("%v{%v} %v\n", name, rm.labels, value(rm)))
+	}
+}
+
+func writeHeader(buffer *bytes.Buffer, name string, kind string, help string) {
+	buffer.WriteString(fmt.Sprintf("# HELP %v %v\n", name, help))
+	buffer.WriteString(fmt.Sprintf("# TYPE %v %v\n", name, kind))
+}
+
+func sortedKeys(nodes map[string]*nodeMetrics) (keys []string) {
+	for key := range nodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// format label pairs as name="value",...
+func formatLabels(pairs ...string) string {
+	labels := []string{}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%v="%v"`, pairs[i], replacer.Replace(pairs[i+1])))
+	}
+	return strings.Join(labels, ",")
+}
+
+// Write the metrics to the given file. The file is replaced at once,
+// collectors reading it never see a partial file.
+func WriteFile(file string, registry *Registry) (err error) {
+	tmpFile := file + ".tmp"
+	err = ioutil.WriteFile(tmpFile, registry.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, file)
+}
+
+// Serve the metrics on the given address, in the background
+func Listen(address string, registry *Registry) (err error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	go http.Serve(listener, mux)
+	return
+}
@@ -0,0 +1,434 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An ansible inventory, reduced to what is needed to build a stage tree
+type AnsibleInventory struct {
+	Hosts  map[string]*AnsibleHost
+	Groups map[string]*AnsibleGroup
+}
+
+type AnsibleHost struct {
+	Name   string
+	Vars   map[string]string
+	Groups []string
+}
+
+type AnsibleGroup struct {
+	Name     string
+	Vars     map[string]string
+	Children []string
+	Parents  []string
+}
+
+func NewAnsibleInventory() *AnsibleInventory {
+	return &AnsibleInventory{
+		Hosts:  make(map[string]*AnsibleHost),
+		Groups: make(map[string]*AnsibleGroup),
+	}
+}
+
+// Read an INI or YAML ansible inventory file
+func ReadAnsibleInventory(file string) (inventory *AnsibleInventory, err error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory = NewAnsibleInventory()
+	if isYamlInventory(file, content) {
+		err = inventory.parseYaml(content)
+	} else {
+		err = inventory.parseIni(content)
+	}
+	if err != nil {
+		errorMsg := fmt.Sprintf("%v: %v", file, err)
+		return nil, errors.New(errorMsg)
+	}
+	return
+}
+
+func isYamlInventory(file string, content []byte) bool {
+	if strings.HasSuffix(file, ".yaml") || strings.HasSuffix(file, ".yml") {
+		return true
+	}
+	trimmed := bytes.TrimSpace(content)
+	return bytes.HasPrefix(trimmed, []byte("---")) || bytes.HasPrefix(trimmed, []byte("all:"))
+}
+
+func (inv *AnsibleInventory) group(name string) *AnsibleGroup {
+	if _, exists := inv.Groups[name]; !exists {
+		inv.Groups[name] = &AnsibleGroup{
+			Name: name,
+			Vars: make(map[string]string),
+		}
+	}
+	return inv.Groups[name]
+}
+
+func (inv *AnsibleInventory) addHost(name string, group string, vars map[string]string) {
+	host, exists := inv.Hosts[name]
+	if !exists {
+		host = &AnsibleHost{
+			Name: name,
+			Vars: make(map[string]string),
+		}
+		inv.Hosts[name] = host
+	}
+	for key, value := range vars {
+		host.Vars[key] = value
+	}
+	inv.group(group)
+	host.Groups = appendUnique(host.Groups, group)
+}
+
+func (inv *AnsibleInventory) addChild(parent string, child string) {
+	inv.group(parent).Children = appendUnique(inv.group(parent).Children, child)
+	inv.group(child).Parents = appendUnique(inv.group(child).Parents, parent)
+}
+
+// Parse an INI inventory with [group] host lines, [group:vars] key=value lines
+// and [group:children] group lines
+func (inv *AnsibleInventory) parseIni(content []byte) (err error) {
+	section := "ungrouped"
+	kind := "hosts"
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = strings.TrimSuffix(strings.TrimPrefix(text, "["), "]")
+			kind = "hosts"
+			if i := strings.Index(section, ":"); i != -1 {
+				kind = section[i+1:]
+				section = section[:i]
+			}
+			inv.group(section)
+			continue
+		}
+
+		fields, err := splitIniFields(text)
+		if err != nil {
+			errorMsg := fmt.Sprintf("line %v: %v", line, err)
+			return errors.New(errorMsg)
+		}
+
+		switch kind {
+		case "hosts":
+			vars, err := parseIniVars(fields[1:])
+			if err != nil {
+				errorMsg := fmt.Sprintf("line %v: %v", line, err)
+				return errors.New(errorMsg)
+			}
+			hosts, err := expandHostPattern(fields[0])
+			if err != nil {
+				errorMsg := fmt.Sprintf("line %v: %v", line, err)
+				return errors.New(errorMsg)
+			}
+			for _, host := range hosts {
+				inv.addHost(host, section, vars)
+			}
+		case "vars":
+			vars, err := parseIniVars(fields)
+			if err != nil {
+				errorMsg := fmt.Sprintf("line %v: %v", line, err)
+				return errors.New(errorMsg)
+			}
+			for key, value := range vars {
+				inv.group(section).Vars[key] = value
+			}
+		case "children":
+			inv.addChild(section, fields[0])
+		default:
+			errorMsg := fmt.Sprintf("line %v: unknown section type '%v'", line, kind)
+			return errors.New(errorMsg)
+		}
+	}
+	return scanner.Err()
+}
+
+// split on spaces, keeping quoted values together
+func splitIniFields(text string) (fields []string, err error) {
+	var current bytes.Buffer
+	var quote rune
+	for _, c := range text {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			if current.Len() == 0 {
+				return fields, nil
+			}
+			current.WriteRune(c)
+		case c == ' ' || c == '\t':
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(c)
+		}
+	}
+	if quote != 0 {
+		return fields, errors.New("unterminated quote")
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+	return
+}
+
+func parseIniVars(fields []string) (vars map[string]string, err error) {
+	vars = make(map[string]string)
+	for _, field := range fields {
+		i := strings.Index(field, "=")
+		if i == -1 {
+			errorMsg := fmt.Sprintf("expected key=value, got '%v'", field)
+			return vars, errors.New(errorMsg)
+		}
+		vars[field[:i]] = field[i+1:]
+	}
+	return
+}
+
+// Expand numeric host ranges like web[01:03].example.com
+func expandHostPattern(pattern string) (hosts []string, err error) {
+	start := strings.Index(pattern, "[")
+	if start == -1 {
+		return []string{pattern}, nil
+	}
+	end := strings.Index(pattern, "]")
+	if end < start {
+		errorMsg := fmt.Sprintf("unterminated host range in '%v'", pattern)
+		return nil, errors.New(errorMsg)
+	}
+	bounds := strings.SplitN(pattern[start+1:end], ":", 2)
+	if len(bounds) != 2 {
+		errorMsg := fmt.Sprintf("invalid host range '%v' in '%v', expected [from:to]", pattern[start:end+1], pattern)
+		return nil, errors.New(errorMsg)
+	}
+	from, errFrom := strconv.Atoi(bounds[0])
+	to, errTo := strconv.Atoi(bounds[1])
+	if errFrom != nil || errTo != nil || from > to {
+		errorMsg := fmt.Sprintf("invalid host range '%v' in '%v', expected numeric bounds", pattern[start:end+1], pattern)
+		return nil, errors.New(errorMsg)
+	}
+	suffixes, err := expandHostPattern(pattern[end+1:])
+	if err != nil {
+		return nil, err
+	}
+	format := fmt.Sprintf("%%0%vd", len(bounds[0]))
+	for i := from; i <= to; i++ {
+		prefix := pattern[:start] + fmt.Sprintf(format, i)
+		for _, suffix := range suffixes {
+			hosts = append(hosts, prefix+suffix)
+		}
+	}
+	return
+}
+
+// Parse a YAML inventory with groups of hosts, vars and children groups
+func (inv *AnsibleInventory) parseYaml(content []byte) (err error) {
+	var groups map[string]interface{}
+	err = yaml.Unmarshal(content, &groups)
+	if err != nil {
+		return err
+	}
+	for name, group := range groups {
+		err = inv.parseYamlGroup(name, group)
+		if err != nil {
+			return err
+		}
+	}
+	return
+}
+
+func (inv *AnsibleInventory) parseYamlGroup(name string, raw interface{}) (err error) {
+	inv.group(name)
+	if raw == nil {
+		return
+	}
+	group, ok := raw.(map[interface{}]interface{})
+	if !ok {
+		errorMsg := fmt.Sprintf("group '%v' is not a mapping", name)
+		return errors.New(errorMsg)
+	}
+
+	if hosts, ok := group["hosts"].(map[interface{}]interface{}); ok {
+		for host, hostVars := range hosts {
+			hostNames, err := expandHostPattern(fmt.Sprintf("%v", host))
+			if err != nil {
+				errorMsg := fmt.Sprintf("group '%v': %v", name, err)
+				return errors.New(errorMsg)
+			}
+			for _, hostName := range hostNames {
+				inv.addHost(hostName, name, yamlStringMap(hostVars))
+			}
+		}
+	}
+
+	for key, value := range yamlStringMap(group["vars"]) {
+		inv.group(name).Vars[key] = value
+	}
+
+	if children, ok := group["children"].(map[interface{}]interface{}); ok {
+		for child, childGroup := range children {
+			childName := fmt.Sprintf("%v", child)
+			inv.addChild(name, childName)
+			err = inv.parseYamlGroup(childName, childGroup)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return
+}
+
+func yamlStringMap(raw interface{}) (vars map[string]string) {
+	vars = make(map[string]string)
+	if m, ok := raw.(map[interface{}]interface{}); ok {
+		for key, value := range m {
+			vars[fmt.Sprintf("%v", key)] = fmt.Sprintf("%v", value)
+		}
+	}
+	return
+}
+
+// Get all groups of the host, including the parent groups
+func (inv *AnsibleInventory) HostGroups(host *AnsibleHost) (groups []string) {
+	var walk func(name string)
+	walk = func(name string) {
+		for _, known := range groups {
+			if known == name {
+				return
+			}
+		}
+		groups = append(groups, name)
+		for _, parent := range inv.Groups[name].Parents {
+			walk(parent)
+		}
+	}
+	for _, group := range host.Groups {
+		walk(group)
+	}
+	return
+}
+
+// Get a host variable. Host vars win over group vars, child group vars over parent group vars.
+func (inv *AnsibleInventory) HostVar(host *AnsibleHost, name string) (value string, exists bool) {
+	if value, exists = host.Vars[name]; exists {
+		return
+	}
+	// groups are ordered from the direct groups up to the parent groups
+	for _, group := range inv.HostGroups(host) {
+		if value, exists = inv.Groups[group].Vars[name]; exists {
+			return
+		}
+	}
+	if value, exists = inv.Groups["all"].Vars[name]; exists {
+		return
+	}
+	return "", false
+}
+
+// Build a stage tree. The parentVar host variable names the parent node,
+// the stageVar host variable names the stage of the root nodes.
+// Host groups become the node tags.
+func (inv *AnsibleInventory) StageTree(parentVar string, stageVar string, defaultStage string) (st StageTree, err error) {
+	inv.group("all")
+
+	names := []string{}
+	for name := range inv.Hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	nodes := make(map[string]*Node)
+	for _, name := range names {
+		host := inv.Hosts[name]
+		node := &Node{Fqdn: name}
+		for _, group := range inv.HostGroups(host) {
+			if group != "all" && group != "ungrouped" {
+				node.Tags = append(node.Tags, group)
+			}
+		}
+		sort.Strings(node.Tags)
+		nodes[name] = node
+	}
+
+	for _, name := range names {
+		host := inv.Hosts[name]
+		parent, hasParent := inv.HostVar(host, parentVar)
+		if hasParent && parent != "" {
+			parentNode, exists := nodes[parent]
+			if !exists {
+				errorMsg := fmt.Sprintf("parent '%v' of host '%v' is not in the inventory", parent, name)
+				return st, errors.New(errorMsg)
+			}
+			parentNode.Children = append(parentNode.Children, nodes[name])
+			continue
+		}
+
+		stageName, hasStage := inv.HostVar(host, stageVar)
+		if !hasStage || stageName == "" {
+			stageName = defaultStage
+		}
+		if stageName == "" {
+			errorMsg := fmt.Sprintf("root host '%v' has no '%v' variable and no default stage is set", name, stageVar)
+			return st, errors.New(errorMsg)
+		}
+		if existing := st.GetStageByName(stageName); existing != nil {
+			errorMsg := fmt.Sprintf("stage '%v' has two root hosts: '%v' and '%v'", stageName, existing.PulpRootNode.Fqdn, name)
+			return st, errors.New(errorMsg)
+		}
+		st.Stages = append(st.Stages, &Stage{
+			Name:         stageName,
+			PulpRootNode: nodes[name],
+		})
+	}
+
+	// hosts in a parent loop are not reachable from any root
+	for _, name := range names {
+		if !st.containsNode(nodes[name]) {
+			errorMsg := fmt.Sprintf("host '%v' is not reachable from a root host (parent loop?)", name)
+			return st, errors.New(errorMsg)
+		}
+	}
+	return
+}
+
+func (st *StageTree) containsNode(node *Node) bool {
+	for _, stage := range st.Stages {
+		found := false
+		stage.NodeTreeWalker(stage.PulpRootNode, func(n *Node) {
+			if n == node {
+				found = true
+			}
+		})
+		if found {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// describe the subtree as fqdn[tags](children...)
+func describeNode(n *Node) string {
+	children := []string{}
+	for _, child := range n.Children {
+		children = append(children, describeNode(child))
+	}
+	return fmt.Sprintf("%v%v(%v)", n.Fqdn, n.Tags, strings.Join(children, " "))
+}
+
+func TestAnsibleInventoryStageTree(t *testing.T) {
+	want := "pulp-lab-root.example.com[](" +
+		"pulp-lab-dc1.example.com[dc sites](" +
+		"pulp-lab-dmz01.example.com[dmz sites]() " +
+		"pulp-lab-dmz02.example.com[dmz sites]()) " +
+		"pulp-lab-dc2.example.com[dc sites]())"
+
+	for _, file := range []string{"hosts.ini", "hosts.yaml"} {
+		inventory, err := ReadAnsibleInventory(filepath.Join("testdata", "inventory", file))
+		if err != nil {
+			t.Errorf("%v: %v", file, err)
+			continue
+		}
+		st, err := inventory.StageTree("pulp_parent", "pulp_stage", "")
+		if err != nil {
+			t.Errorf("%v: %v", file, err)
+			continue
+		}
+		if len(st.Stages) != 1 || st.Stages[0].Name != "lab" {
+			t.Errorf("%v: expected the single stage 'lab', got %v stage(s)", file, len(st.Stages))
+			continue
+		}
+		if got := describeNode(st.Stages[0].PulpRootNode); got != want {
+			t.Errorf("%v:\n got %v\nwant %v", file, got, want)
+		}
+	}
+}
+
+func TestAnsibleInventoryErrors(t *testing.T) {
+	tests := []struct {
+		file string
+		err  string
+	}{
+		{"broken_range.ini", "line 4: invalid host range '[1:x]'"},
+		{"broken_range.yaml", "unterminated host range"},
+		{"missing_parent.ini", "parent 'pulp-lab-gone.example.com' of host 'pulp-lab-dc1.example.com' is not in the inventory"},
+		{"missing_parent.yaml", "parent 'pulp-lab-gone.example.com' of host 'pulp-lab-dc1.example.com' is not in the inventory"},
+	}
+	for _, test := range tests {
+		inventory, err := ReadAnsibleInventory(filepath.Join("testdata", "inventory", test.file))
+		if err == nil {
+			_, err = inventory.StageTree("pulp_parent", "pulp_stage", "")
+		}
+		if err == nil {
+			t.Errorf("%v: expected an error containing \"%v\"", test.file, test.err)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: got error \"%v\", want \"%v\"", test.file, err, test.err)
+		}
+	}
+}
+
+func TestExpandHostPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		hosts   []string
+		valid   bool
+	}{
+		{"web.example.com", []string{"web.example.com"}, true},
+		{"web[1:3].example.com", []string{"web1.example.com", "web2.example.com", "web3.example.com"}, true},
+		{"web[08:10]", []string{"web08", "web09", "web10"}, true},
+		{"dc[1:2]-web[1:2]", []string{"dc1-web1", "dc1-web2", "dc2-web1", "dc2-web2"}, true},
+		{"web[3:1]", nil, false},
+		{"web[a:c]", nil, false},
+		{"web[1]", nil, false},
+		{"web[1:2", nil, false},
+	}
+	for _, test := range tests {
+		hosts, err := expandHostPattern(test.pattern)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%v: expected an error, got %v", test.pattern, hosts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.pattern, err)
+			continue
+		}
+		if strings.Join(hosts, ",") != strings.Join(test.hosts, ",") {
+			t.Errorf("%v: got %v, want %v", test.pattern, hosts, test.hosts)
+		}
+	}
+}
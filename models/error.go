@@ -1,6 +1,83 @@
 package models
 
 import (
-// "bytes"
-// "fmt"
+	// "bytes"
+	// "fmt"
+	"github.com/msutter/go-pulp/pulp"
+	"net"
+	"net/url"
+	"strings"
 )
+
+// The kinds of the errors found by nodetree itself
+const (
+	MissingRepositoryError       = "missing repository"
+	MissingParentRepositoryError = "missing parent repository"
+	FeedError                    = "feed"
+	WorkersError                 = "workers"
+	DatabaseError                = "database"
+	MessagingError               = "messaging"
+)
+
+var errorHints = map[string]string{
+	MissingRepositoryError:       "create the repository on the node, or exclude it with 'repositories: exclude'",
+	MissingParentRepositoryError: "create the repository on the parent node first",
+	FeedError:                    "point the feed of the repository importer to the parent node",
+	WorkersError:                 "start the pulp workers of the node, see 'nodetree pulp health'",
+	DatabaseError:                "check the database service of the node",
+	MessagingError:               "check the messaging broker of the node",
+}
+
+// An error found by nodetree itself, e.g. a missing repository, with its kind
+type KindError struct {
+	Kind    string
+	Message string
+}
+
+func (e *KindError) Error() string {
+	return e.Message
+}
+
+func newKindError(kind string, message string) error {
+	return &KindError{Kind: kind, Message: message}
+}
+
+// Is the error returned by a pulp api call, rather than by a sync task?
+func IsApiError(err error) bool {
+	switch err.(type) {
+	case *pulp.ErrorResponse, *url.Error, net.Error:
+		return true
+	}
+	return false
+}
+
+// Classify the error and give a short remediation hint
+func ErrorKind(err error) (kind string, hint string) {
+	if netErr, isNetError := err.(net.Error); isNetError && netErr.Timeout() {
+		return "timeout", "check the load of the node, or raise the timeouts in its tag settings"
+	}
+
+	message := err.Error()
+	switch e := err.(type) {
+	case *KindError:
+		return e.Kind, errorHints[e.Kind]
+	case *pulp.ErrorResponse:
+		switch {
+		case e.Response == nil:
+		case e.Response.StatusCode == 401 || e.Response.StatusCode == 403:
+			return "authentication", "check the apiuser and apipasswd of the node, its tags or the tree"
+		case e.Response.StatusCode == 404:
+			return "not found", "check that the repository exists on the node"
+		case e.Response.StatusCode >= 500:
+			return "server error", "check the pulp logs of the node and 'nodetree pulp health'"
+		}
+		return "api", "check the pulp logs of the node"
+	case *url.Error:
+		if strings.Contains(message, "x509") || strings.Contains(message, "tls") {
+			return "tls", "check the certificate of the node, or the ssl_ca_file tag setting"
+		}
+		return "unreachable", "check that the node is up, resolvable and reachable on the api port"
+	}
+
+	return "sync task", "check the task in the pulp logs of the node and the repository on the parent node"
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"errors"
+	"github.com/msutter/go-pulp/pulp"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// an api error response with the given status
+func errorResponse(status int) error {
+	req, _ := http.NewRequest("GET", "https://dc1/pulp/api/v2/repositories/", nil)
+	return &pulp.ErrorResponse{Response: &http.Response{StatusCode: status, Request: req}}
+}
+
+type timeoutError struct{}
+
+func (e timeoutError) Error() string   { return "i/o timeout" }
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind string
+	}{
+		{newKindError(MissingRepositoryError, "repository 'rhel7-os' does not exist on node dc1"), "missing repository"},
+		{newKindError(MissingParentRepositoryError, "reworded"), "missing parent repository"},
+		{newKindError(FeedError, "reworded"), "feed"},
+		{newKindError(WorkersError, "reworded"), "workers"},
+		{newKindError(DatabaseError, "reworded"), "database"},
+		{newKindError(MessagingError, "reworded"), "messaging"},
+		{errorResponse(401), "authentication"},
+		{errorResponse(404), "not found"},
+		{errorResponse(503), "server error"},
+		{&url.Error{Op: "Get", URL: "https://dc1/", Err: errors.New("connection refused")}, "unreachable"},
+		{&url.Error{Op: "Get", URL: "https://dc1/", Err: errors.New("x509: certificate signed by unknown authority")}, "tls"},
+		{&url.Error{Op: "Get", URL: "https://dc1/", Err: timeoutError{}}, "timeout"},
+		// the messages alone do not classify the errors
+		{errors.New("repository 'rhel7-os' does not exist on node dc1"), "sync task"},
+		{errors.New("metadata download failed"), "sync task"},
+	}
+	for _, test := range tests {
+		kind, hint := ErrorKind(test.err)
+		if kind != test.kind {
+			t.Errorf("%v: got kind '%v', want '%v'", test.err, kind, test.kind)
+		}
+		if hint == "" {
+			t.Errorf("%v: no hint", test.err)
+		}
+	}
+}
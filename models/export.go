@@ -0,0 +1,108 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// fill colors assigned to the tags, in order of the sorted tag names
+var tagColors = []string{
+	"#8dd3c7", "#ffffb3", "#bebada", "#80b1d3", "#fdb462",
+	"#b3de69", "#fccde5", "#d9d9d9", "#bc80bd", "#ccebc5",
+}
+
+const (
+	defaultNodeColor = "#ffffff"
+	errorNodeColor   = "#fb8072"
+	passedNodeColor  = "#b3de69"
+)
+
+// Get the fill color of the node, by error state if node states are given, else by tag
+func (s *Stage) nodeColor(n *Node, colors map[string]string, states map[string]*NodeState) string {
+	if states != nil {
+		if ns, exists := states[n.Fqdn]; exists {
+			if ns.HasError() {
+				return errorNodeColor
+			}
+			return passedNodeColor
+		}
+		return defaultNodeColor
+	}
+	if len(n.Tags) > 0 {
+		return colors[n.Tags[0]]
+	}
+	return defaultNodeColor
+}
+
+// Assign a color to each tag of the stage
+func (s *Stage) tagColors() (colors map[string]string) {
+	var tags []string
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		for _, tag := range n.Tags {
+			tags = appendUnique(tags, tag)
+		}
+	})
+	sort.Strings(tags)
+
+	colors = make(map[string]string)
+	for i, tag := range tags {
+		colors[tag] = tagColors[i%len(tagColors)]
+	}
+	return
+}
+
+func (n *Node) label(separator string) string {
+	if len(n.Tags) == 0 {
+		return n.Fqdn
+	}
+	return n.Fqdn + separator + strings.Join(n.Tags, ", ")
+}
+
+// Render the stage as a Graphviz DOT graph.
+// Nodes are colored by error state if node states are given, else by tag.
+func (s *Stage) Dot(states map[string]*NodeState) string {
+	s.Init()
+	colors := s.tagColors()
+
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("digraph %q {\n", s.Name))
+	buffer.WriteString("  node [shape=box, style=\"rounded,filled\"];\n")
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		buffer.WriteString(fmt.Sprintf("  %q [label=%q, fillcolor=%q];\n",
+			n.Fqdn,
+			n.label("\n"),
+			s.nodeColor(n, colors, states)))
+	})
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		for _, child := range n.Children {
+			buffer.WriteString(fmt.Sprintf("  %q -> %q;\n", n.Fqdn, child.Fqdn))
+		}
+	})
+	buffer.WriteString("}\n")
+	return buffer.String()
+}
+
+// Render the stage as a Mermaid flowchart.
+// Nodes are colored by error state if node states are given, else by tag.
+func (s *Stage) Mermaid(states map[string]*NodeState) string {
+	s.Init()
+	colors := s.tagColors()
+
+	var buffer bytes.Buffer
+	buffer.WriteString("graph TD\n")
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		label := strings.Replace(n.label("<br/>"), "\"", "#quot;", -1)
+		buffer.WriteString(fmt.Sprintf("  n%v[\"%v\"]\n", n.TreePosition, label))
+	})
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		for _, child := range n.Children {
+			buffer.WriteString(fmt.Sprintf("  n%v --> n%v\n", n.TreePosition, child.TreePosition))
+		}
+	})
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		buffer.WriteString(fmt.Sprintf("  style n%v fill:%v\n", n.TreePosition, s.nodeColor(n, colors, states)))
+	})
+	return buffer.String()
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// What a sync does once repositories fail: 'continue' with everything not
+// depending on the failures, 'fail-fast' stops starting syncs, 'stop-level'
+// finishes the level of the failing node but starts no syncs below it.
+// The policy triggers at MaxFailures failures, a failure being a failed
+// repository sync or a failed node.
+type FailurePolicy struct {
+	Mode        string
+	MaxFailures int
+	// cancel the running tasks the policy stops
+	CancelTasks bool
+
+	mu         sync.Mutex
+	failures   int
+	failed     map[string]bool
+	triggered  bool
+	stopDepth  int
+	reason     string
+	notStarted []*Node
+}
+
+var FailureModes = []string{"continue", "fail-fast", "stop-level"}
+
+// Get the failure policy of the mode. A max failures of 0 means the default:
+// unlimited for 'continue', 1 for the others.
+func NewFailurePolicy(mode string, maxFailures int, cancelTasks bool) (fp *FailurePolicy, err error) {
+	if posString(FailureModes, mode) == -1 {
+		errorMsg := fmt.Sprintf("unknown failure mode '%v', valid modes are %v", mode, FailureModes)
+		return nil, errors.New(errorMsg)
+	}
+	if maxFailures < 0 {
+		errorMsg := fmt.Sprintf("invalid max failures %v", maxFailures)
+		return nil, errors.New(errorMsg)
+	}
+	if maxFailures == 0 && mode != "continue" {
+		maxFailures = 1
+	}
+	return &FailurePolicy{
+		Mode:        mode,
+		MaxFailures: maxFailures,
+		CancelTasks: cancelTasks,
+	}, nil
+}
+
+// Count the failed syncs and trigger the policy at the max failures
+func (fp *FailurePolicy) Observe(sp SyncProgress) {
+	if fp == nil || sp.State != "error" {
+		return
+	}
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	// the repositories of a failed node fail with the node
+	failure := sp.Node.Fqdn
+	if !sp.NodeError {
+		failure += " " + sp.Repository
+	}
+	if fp.failed == nil {
+		fp.failed = make(map[string]bool)
+	}
+	if fp.failed[failure] {
+		return
+	}
+	fp.failed[failure] = true
+
+	fp.failures++
+	if fp.triggered || fp.MaxFailures == 0 || fp.failures < fp.MaxFailures {
+		return
+	}
+	fp.triggered = true
+
+	last := fmt.Sprintf("the last on %v %v", sp.Node.Fqdn, sp.Repository)
+	switch fp.Mode {
+	case "stop-level":
+		fp.stopDepth = sp.Node.Depth
+		fp.reason = fmt.Sprintf("stop-level: no syncs below level %v after %v failure(s), %v", fp.stopDepth, fp.failures, last)
+	case "fail-fast":
+		fp.stopDepth = -1
+		fp.reason = fmt.Sprintf("fail-fast: stopped after %v failure(s), %v", fp.failures, last)
+	default:
+		fp.stopDepth = -1
+		fp.reason = fmt.Sprintf("max failures: stopped after %v failure(s), %v", fp.failures, last)
+	}
+}
+
+// May the node start syncs?
+func (fp *FailurePolicy) Allows(n *Node) bool {
+	if fp == nil {
+		return true
+	}
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return !fp.triggered || (fp.stopDepth >= 0 && n.Depth <= fp.stopDepth)
+}
+
+// Get why the policy stopped the syncs, empty if it did not trigger
+func (fp *FailurePolicy) Reason() string {
+	if fp == nil {
+		return ""
+	}
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return fp.reason
+}
+
+func (fp *FailurePolicy) skipNode(n *Node) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.notStarted = append(fp.notStarted, n)
+}
+
+// Get the nodes the policy kept from starting
+func (fp *FailurePolicy) NotStarted() []*Node {
+	if fp == nil {
+		return nil
+	}
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return fp.notStarted
+}
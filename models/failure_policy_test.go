@@ -0,0 +1,178 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// a failed sync of the repository on the node
+func failedSync(s *Stage, fqdn string, repository string) SyncProgress {
+	return SyncProgress{
+		Node:       s.GetNodeByFqdn(fqdn),
+		Repository: repository,
+		State:      "error",
+		Error:      errors.New("sync failed"),
+	}
+}
+
+// the nodes of the stage allowed to start syncs
+func allowedNodes(s *Stage, fp *FailurePolicy) (allowed map[string]bool) {
+	allowed = make(map[string]bool)
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		allowed[n.Fqdn] = fp.Allows(n)
+	})
+	return
+}
+
+func TestNewFailurePolicy(t *testing.T) {
+	tests := []struct {
+		mode        string
+		maxFailures int
+		want        int
+		valid       bool
+	}{
+		{"continue", 0, 0, true},
+		{"continue", 3, 3, true},
+		{"fail-fast", 0, 1, true},
+		{"stop-level", 2, 2, true},
+		{"abort", 0, 0, false},
+		{"fail-fast", -1, 0, false},
+	}
+	for _, test := range tests {
+		fp, err := NewFailurePolicy(test.mode, test.maxFailures, false)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%v %v: expected an error", test.mode, test.maxFailures)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v %v: %v", test.mode, test.maxFailures, err)
+			continue
+		}
+		if fp.MaxFailures != test.want {
+			t.Errorf("%v %v: got max failures %v, want %v", test.mode, test.maxFailures, fp.MaxFailures, test.want)
+		}
+	}
+}
+
+func TestFailurePolicyAllows(t *testing.T) {
+	s := newTestStage()
+	all := map[string]bool{"root": true, "dc1": true, "dmz1": true, "dc2": true}
+	none := map[string]bool{"root": false, "dc1": false, "dmz1": false, "dc2": false}
+	tests := []struct {
+		mode        string
+		maxFailures int
+		failures    []SyncProgress
+		allowed     map[string]bool
+	}{
+		{"fail-fast", 1, nil, all},
+		// continue never stops the syncs
+		{"continue", 0, []SyncProgress{failedSync(s, "dc1", "rhel7-os"), failedSync(s, "dc2", "rhel7-os")}, all},
+		// fail-fast stops all syncs at the first failure
+		{"fail-fast", 1, []SyncProgress{failedSync(s, "dc1", "rhel7-os")}, none},
+		// stop-level lets the level of the failing node finish
+		{"stop-level", 1, []SyncProgress{failedSync(s, "dc1", "rhel7-os")},
+			map[string]bool{"root": true, "dc1": true, "dmz1": false, "dc2": true}},
+		// max failures: the first failure does not trigger the policy
+		{"stop-level", 2, []SyncProgress{failedSync(s, "dc1", "rhel7-os")}, all},
+		{"fail-fast", 2, []SyncProgress{failedSync(s, "dc1", "rhel7-os"), failedSync(s, "dc2", "rhel7-os")}, none},
+		{"continue", 2, []SyncProgress{failedSync(s, "dc1", "rhel7-os"), failedSync(s, "dc1", "rhel7-updates")}, none},
+		// the same failure is counted once
+		{"fail-fast", 2, []SyncProgress{failedSync(s, "dc1", "rhel7-os"), failedSync(s, "dc1", "rhel7-os")}, all},
+	}
+
+	for _, test := range tests {
+		fp, err := NewFailurePolicy(test.mode, test.maxFailures, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sp := range test.failures {
+			fp.Observe(sp)
+		}
+		allowed := allowedNodes(s, fp)
+		for fqdn, want := range test.allowed {
+			if allowed[fqdn] != want {
+				t.Errorf("%v %v after %v failure(s): node %v allowed %v, want %v",
+					test.mode, test.maxFailures, len(test.failures), fqdn, allowed[fqdn], want)
+			}
+		}
+		if triggered := fp.Reason() != ""; triggered != !allowed["dmz1"] {
+			t.Errorf("%v %v: the reason '%v' does not match the stopped syncs", test.mode, test.maxFailures, fp.Reason())
+		}
+	}
+}
+
+func TestFailurePolicyCountsNodeFailureOnce(t *testing.T) {
+	s := newTestStage()
+	fp, err := NewFailurePolicy("fail-fast", 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an unreachable node fails all its repositories with the same error
+	unreachable := errors.New("connection refused")
+	for _, repository := range []string{"rhel7-os", "rhel7-updates", "rhel7-extras", "rhel7-optional", "rhel7-debug"} {
+		fp.Observe(SyncProgress{
+			Node:       s.GetNodeByFqdn("dc1"),
+			Repository: repository,
+			State:      "error",
+			Error:      unreachable,
+			NodeError:  true,
+		})
+	}
+	if fp.Reason() != "" {
+		t.Fatalf("the failed node counts more than once: %v", fp.Reason())
+	}
+
+	// other events are no failures
+	fp.Observe(SyncProgress{Node: s.GetNodeByFqdn("dc2"), Repository: "rhel7-os", State: "finished"})
+	fp.Observe(SyncProgress{Node: s.GetNodeByFqdn("dmz1"), Repository: "rhel7-os", State: "skipped"})
+	if fp.Reason() != "" {
+		t.Fatalf("the policy triggered without a second failure: %v", fp.Reason())
+	}
+
+	fp.Observe(failedSync(s, "dc2", "rhel7-os"))
+	if fp.Reason() == "" || fp.Allows(s.GetNodeByFqdn("dc2")) {
+		t.Errorf("the policy did not trigger at the second failure")
+	}
+}
+
+func TestFailurePolicyStopsSyncsBeforeTheErrorIsReceived(t *testing.T) {
+	ActiveSimulation = NewSimulation(SimulationSettings{}, 1000, 1)
+	defer func() { ActiveSimulation = nil }()
+	ActiveSimulation.AddRepositories([]string{"rhel7-os"})
+
+	s := newTestStage()
+	fp, err := NewFailurePolicy("fail-fast", 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		n.policy = fp
+	})
+
+	// nobody receives the error of dc1 yet, like behind a slow renderer
+	progress := make(chan SyncProgress)
+	go s.GetNodeByFqdn("dc1").sendProgress(progress, failedSync(s, "dc1", "rhel7-os"))
+	for deadline := time.Now().Add(time.Second); fp.Reason() == ""; {
+		if time.Now().After(deadline) {
+			t.Fatalf("the policy did not see the error before it was received")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// no sync starts on the other nodes
+	dc2Progress := make(chan SyncProgress, 1)
+	if err := s.GetNodeByFqdn("dc2").Sync([]string{"rhel7-os"}, dc2Progress); err != nil {
+		t.Fatal(err)
+	}
+	if sp := <-dc2Progress; sp.State != "skipped" {
+		t.Errorf("expected the sync of dc2 to be skipped, got %v", sp.State)
+	}
+	if len(ActiveSimulation.tasks) != 0 {
+		t.Errorf("%v sync(s) started after the failure", len(ActiveSimulation.tasks))
+	}
+	<-progress
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A node below a failing node, with the repositories the failure cost it
+type BlockedNode struct {
+	Fqdn string
+	// the depth below the failing node
+	Depth        int
+	Repositories []string
+}
+
+// An originating failure with the subtree it blocked.
+// Node failures block all repositories of the descendants, repository
+// failures only the same repository.
+type FailureGroup struct {
+	Fqdn string
+	// empty for node failures
+	Repository string
+	Errors     []string
+	Kind       string
+	Hint       string
+	Blocked    []*BlockedNode
+}
+
+// Is the node failing as a whole: unreachable, unhealthy or failing the preflight
+func (n *Node) hasNodeFailure() bool {
+	return len(n.Errors) > 0 || n.PreflightFailed
+}
+
+// Did the repository fail or get skipped on the node?
+func (n *Node) repositoryFailed(repository string) bool {
+	if _, hasError := n.RepositoryError[repository]; hasError {
+		return true
+	}
+	state := n.syncStates[repository]
+	return state == "error" || state == "skipped"
+}
+
+// Is the failure of the repository on the node caused by an ancestor?
+func (n *Node) failureFromAncestor(repository string) bool {
+	for ancestor := n.Parent; ancestor != nil; ancestor = ancestor.Parent {
+		if ancestor.hasNodeFailure() {
+			return true
+		}
+		if repository != "" && ancestor.repositoryFailed(repository) {
+			return true
+		}
+	}
+	return false
+}
+
+// Group the failures of the stage by root cause: each originating failure once,
+// with the descendants it blocked. Groups blocking other nodes come first,
+// followed by the independent failures.
+func (s *Stage) FailureGroups() (groups []*FailureGroup) {
+	if s.PulpRootNode == nil {
+		return
+	}
+
+	var independent []*FailureGroup
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		var nodeGroups []*FailureGroup
+		if n.hasNodeFailure() {
+			if n.failureFromAncestor("") {
+				return
+			}
+			group := &FailureGroup{Fqdn: n.Fqdn}
+			for _, err := range n.Errors {
+				group.Errors = append(group.Errors, err.Error())
+			}
+			for _, repository := range sortedRepositoryErrors(n) {
+				group.Errors = append(group.Errors, fmt.Sprintf("%v: %v", repository, n.RepositoryError[repository]))
+			}
+			group.Kind, group.Hint = failureKind(n)
+			group.Blocked = n.blockedBy("")
+			nodeGroups = append(nodeGroups, group)
+		} else {
+			for _, repository := range sortedRepositoryErrors(n) {
+				if n.failureFromAncestor(repository) {
+					continue
+				}
+				err := n.RepositoryError[repository]
+				group := &FailureGroup{
+					Fqdn:       n.Fqdn,
+					Repository: repository,
+					Errors:     []string{err.Error()},
+				}
+				group.Kind, group.Hint = ErrorKind(err)
+				group.Blocked = n.blockedBy(repository)
+				nodeGroups = append(nodeGroups, group)
+			}
+		}
+
+		for _, group := range nodeGroups {
+			if len(group.Blocked) > 0 {
+				groups = append(groups, group)
+			} else {
+				independent = append(independent, group)
+			}
+		}
+	})
+	return append(groups, independent...)
+}
+
+func sortedRepositoryErrors(n *Node) (repositories []string) {
+	for repository := range n.RepositoryError {
+		repositories = append(repositories, repository)
+	}
+	sort.Strings(repositories)
+	return
+}
+
+// the kind of the first error of a failing node
+func failureKind(n *Node) (kind string, hint string) {
+	if len(n.Errors) > 0 {
+		return ErrorKind(n.Errors[0])
+	}
+	if repositories := sortedRepositoryErrors(n); len(repositories) > 0 {
+		return ErrorKind(n.RepositoryError[repositories[0]])
+	}
+	return "preflight", "fix the preflight failures of the node"
+}
+
+// Get the descendants failing because of the node, all their
+// repositories if the repository is empty
+func (n *Node) blockedBy(repository string) (blocked []*BlockedNode) {
+	n.ChildTreeWalker(func(descendant *Node) {
+		bn := &BlockedNode{Fqdn: descendant.Fqdn, Depth: descendant.Depth - n.Depth}
+		if repository != "" {
+			if descendant.repositoryFailed(repository) {
+				bn.Repositories = []string{repository}
+			}
+		} else {
+			bn.Repositories = descendant.failedRepositories()
+		}
+		if len(bn.Repositories) > 0 || (repository == "" && descendant.hasNodeFailure()) {
+			blocked = append(blocked, bn)
+		}
+	})
+	return
+}
+
+// Get the repositories failed or skipped on the node, sorted
+func (n *Node) failedRepositories() (repositories []string) {
+	for repository := range n.RepositoryError {
+		repositories = appendUnique(repositories, repository)
+	}
+	for repository := range n.syncStates {
+		if n.repositoryFailed(repository) {
+			repositories = appendUnique(repositories, repository)
+		}
+	}
+	sort.Strings(repositories)
+	return
+}
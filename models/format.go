@@ -0,0 +1,43 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Format a byte count with a binary unit
+func FormatBytes(size int) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%v %v", size, units[unit])
+	}
+	return fmt.Sprintf("%.1f %v", value, units[unit])
+}
+
+// Format the duration between two times to the second, '-' if not ended
+func FormatDuration(start time.Time, end time.Time) string {
+	if end.IsZero() || start.IsZero() {
+		return "-"
+	}
+	seconds := int64(end.Sub(start)) / int64(time.Second)
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+// Format a throughput in bytes per second, '-' if unknown
+func FormatThroughput(bytesPerSecond float64) string {
+	if bytesPerSecond <= 0 {
+		return "-"
+	}
+	return FormatBytes(int(bytesPerSecond)) + "/s"
+}
+
+// Format a number of seconds to the second
+func FormatSeconds(seconds float64) string {
+	return (time.Duration(int64(seconds)) * time.Second).String()
+}
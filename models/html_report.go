@@ -0,0 +1,142 @@
+package models
+
+import (
+	"bytes"
+	"html/template"
+	"time"
+)
+
+type htmlNode struct {
+	Fqdn     string
+	Tags     []string
+	State    string
+	Errors   []string
+	Results  []*RepositoryResult
+	Children []*htmlNode
+}
+
+type htmlReport struct {
+	Run       *Run
+	Counts    RunCounts
+	Duration  string
+	Generated string
+	Root      *htmlNode
+}
+
+var htmlFuncs = template.FuncMap{
+	"bytes":      FormatBytes,
+	"duration":   FormatDuration,
+	"throughput": FormatThroughput,
+	"seconds":    FormatSeconds,
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format(time.RFC3339)
+	},
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(htmlFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>nodetree {{.Run.Command}} {{.Run.Stage}} {{.Run.Id}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 2em; color: #222; }
+h1 { font-size: 20px; }
+table { border-collapse: collapse; margin: 0.3em 0 0.6em 0; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+th { background: #eee; }
+ul.tree { list-style: none; padding-left: 1.5em; border-left: 1px dotted #999; }
+ul.tree.root { border-left: none; padding-left: 0; }
+summary { cursor: pointer; padding: 2px 0; }
+.tag { background: #ddd; border-radius: 3px; padding: 0 4px; margin-left: 4px; font-size: 12px; }
+.state-passed, .state-finished, .state-ok { color: #1a7f37; }
+.state-error, .state-failed { color: #cf222e; font-weight: bold; }
+.state-skipped { color: #8250df; }
+.state-not-applicable { color: #0a7ea4; }
+.errors { color: #cf222e; margin: 0.2em 0; }
+</style>
+</head>
+<body>
+<h1>nodetree {{.Run.Command}} of stage '{{.Run.Stage}}'</h1>
+<table>
+<tr><th>run</th><td>{{.Run.Id}}</td></tr>
+<tr><th>state</th><td class="state-{{.Run.State}}">{{.Run.State}}{{if .Run.Message}}: {{.Run.Message}}{{end}}</td></tr>
+<tr><th>trigger</th><td>{{.Run.Trigger}}</td></tr>
+<tr><th>user</th><td>{{.Run.User}}</td></tr>
+<tr><th>start</th><td>{{time .Run.Start}}</td></tr>
+<tr><th>end</th><td>{{time .Run.End}}</td></tr>
+<tr><th>duration</th><td>{{.Duration}}</td></tr>
+{{if .Run.StageEtaSeconds}}<tr><th>stage eta</th><td>{{seconds .Run.StageEtaSeconds}}</td></tr>{{end}}
+{{if .Run.Fqdns}}<tr><th>fqdns</th><td>{{range .Run.Fqdns}}{{.}} {{end}}</td></tr>{{end}}
+{{if .Run.Tags}}<tr><th>tags</th><td>{{range .Run.Tags}}{{.}} {{end}}</td></tr>{{end}}
+<tr><th>repositories</th><td>{{range .Run.Repositories}}{{.}} {{end}}</td></tr>
+<tr><th>nodes</th><td>{{.Counts.Nodes}} ({{.Counts.FailedNodes}} failed)</td></tr>
+<tr><th>results</th><td>{{.Counts.Finished}} ok, {{.Counts.Failed}} failed, {{.Counts.Skipped}} skipped, {{.Counts.NotApplicable}} not applicable</td></tr>
+<tr><th>transferred</th><td>{{bytes .Counts.SizeTotal}}, {{.Counts.ItemsTotal}} items</td></tr>
+</table>
+{{if .Root}}<ul class="tree root">{{template "node" .Root}}</ul>{{end}}
+<p><small>generated {{.Generated}}</small></p>
+</body>
+</html>
+{{define "node"}}<li><details open>
+<summary><span class="state-{{.State}}">{{.Fqdn}}</span>{{range .Tags}}<span class="tag">{{.}}</span>{{end}}</summary>
+{{range .Errors}}<div class="errors">{{.}}</div>{{end}}
+{{if .Results}}<table>
+<tr><th>repository</th><th>state</th><th>task</th><th>duration</th><th>size</th><th>items</th><th>throughput</th><th>message</th></tr>
+{{range .Results}}<tr><td>{{.Repository}}</td><td class="state-{{if eq .State "not applicable"}}not-applicable{{else}}{{.State}}{{end}}">{{.State}}{{if .EtaSeconds}}, eta {{seconds .EtaSeconds}}{{end}}</td><td>{{.TaskId}}</td><td>{{duration .Start .End}}</td><td>{{bytes .SizeTotal}}</td><td>{{.ItemsTotal}}</td><td>{{throughput .Throughput}}</td><td>{{if .Error}}{{.Error}}{{else}}{{.Message}}{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Children}}<ul class="tree">{{range .Children}}{{template "node" .}}{{end}}</ul>{{end}}
+</details></li>
+{{end}}`))
+
+func (r *Run) htmlNode(n *Node) *htmlNode {
+	hn := &htmlNode{
+		Fqdn:  n.Fqdn,
+		Tags:  n.Tags,
+		State: "ok",
+	}
+	if ns, exists := r.Nodes[n.Fqdn]; exists {
+		hn.Errors = ns.Errors
+		for repository, e := range ns.RepositoryError {
+			if r.GetResult(n.Fqdn, repository) == nil {
+				hn.Errors = append(hn.Errors, repository+": "+e)
+			}
+		}
+		if ns.HasError() {
+			hn.State = "error"
+		}
+	}
+	for _, result := range r.Results {
+		if result.Fqdn == n.Fqdn {
+			hn.Results = append(hn.Results, result)
+		}
+	}
+	for _, child := range n.Children {
+		hn.Children = append(hn.Children, r.htmlNode(child))
+	}
+	return hn
+}
+
+// Render the run on the tree of the stage as a self-contained HTML page
+func (r *Run) HTML(s *Stage) ([]byte, error) {
+	counts := r.Counts()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := &htmlReport{
+		Run:       r,
+		Counts:    counts,
+		Duration:  FormatDuration(r.Start, r.End),
+		Generated: time.Now().Format(time.RFC3339),
+	}
+	if s != nil && s.PulpRootNode != nil {
+		report.Root = r.htmlNode(s.PulpRootNode)
+	}
+
+	var buffer bytes.Buffer
+	err := htmlTemplate.Execute(&buffer, report)
+	return buffer.Bytes(), err
+}
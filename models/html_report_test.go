@@ -0,0 +1,37 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunHTMLEscapesErrors(t *testing.T) {
+	content, err := newTestRun().HTML(newTestStage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(content)
+
+	tests := []struct {
+		escaped string
+		raw     string
+	}{
+		// the error of a repository result
+		{"&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt;", "<script>"},
+		// the error of a node
+		{"no live workers on &lt;b&gt;dc2&lt;/b&gt;", "<b>dc2</b>"},
+	}
+	for _, test := range tests {
+		if !strings.Contains(html, test.escaped) {
+			t.Errorf("'%v' is missing", test.escaped)
+		}
+		if strings.Contains(html, test.raw) {
+			t.Errorf("'%v' is not escaped", test.raw)
+		}
+	}
+
+	// the repository error without a result is listed with the node errors
+	if !strings.Contains(html, "rhel7-extras: repository not found") {
+		t.Errorf("the repository error of dmz1 is missing")
+	}
+}
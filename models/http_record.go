@@ -0,0 +1,250 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Save the pulp api exchanges of each node in this directory
+var RecordDir string
+
+// Serve the pulp api responses saved in this directory instead of contacting the nodes
+var ReplayDir string
+
+// A recorded pulp api request and its response
+type Exchange struct {
+	Method       string
+	Url          string
+	RequestBody  string `json:",omitempty"`
+	Status       int
+	Header       http.Header
+	ResponseBody string
+	Error        string `json:",omitempty"`
+}
+
+var recordingsMu sync.Mutex
+
+// the replayed exchanges of each node, by fqdn
+var recordings = make(map[string][]*Exchange)
+
+// the recording file of each node, by fqdn
+var recorders = make(map[string]*recorder)
+
+// A recording file, the exchanges of the node are appended one per line
+type recorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// the position of the next replayed exchange of each node and request
+var replayCursors = make(map[string]int)
+
+func recordingFile(dir string, fqdn string) string {
+	return filepath.Join(dir, strings.Replace(fqdn, ":", "_", -1)+".jsonl")
+}
+
+// the url without the host, which may differ between recording and replay
+func exchangeUrl(req *http.Request) string {
+	path := req.URL.Opaque
+	if path == "" {
+		path = req.URL.Path
+	}
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+	return path
+}
+
+// Read and close the body
+func readBody(reader io.ReadCloser) (body []byte, err error) {
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// An http transport saving the exchanges of a node
+type recordTransport struct {
+	next http.RoundTripper
+	node *Node
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	exchange := &Exchange{
+		Method: req.Method,
+		Url:    exchangeUrl(req),
+	}
+	if req.Body != nil {
+		body, err := readBody(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		exchange.RequestBody = Redact(string(body))
+	}
+
+	resp, err = t.next.RoundTrip(req)
+	if err != nil {
+		// unreachable nodes are replayed too
+		exchange.Error = err.Error()
+		saveExchange(t.node.Fqdn, exchange)
+		return resp, err
+	}
+
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	exchange.Status = resp.StatusCode
+	exchange.Header = resp.Header
+	exchange.ResponseBody = Redact(string(body))
+
+	return resp, saveExchange(t.node.Fqdn, exchange)
+}
+
+// Get the recorder of the node, the first call replaces the previous recording
+func nodeRecorder(fqdn string) (r *recorder, err error) {
+	recordingsMu.Lock()
+	defer recordingsMu.Unlock()
+
+	if r, exists := recorders[fqdn]; exists {
+		return r, nil
+	}
+	err = os.MkdirAll(RecordDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(recordingFile(RecordDir, fqdn), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r = &recorder{file: file}
+	recorders[fqdn] = r
+	return
+}
+
+// Append the exchange to the recording of the node
+func saveExchange(fqdn string, exchange *Exchange) (err error) {
+	r, err := nodeRecorder(fqdn)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.file.Write(append(content, '\n'))
+	return err
+}
+
+// Load the recording of the node from the replay directory
+func loadRecording(fqdn string) (exchanges []*Exchange, err error) {
+	recordingsMu.Lock()
+	defer recordingsMu.Unlock()
+
+	if exchanges, exists := recordings[fqdn]; exists {
+		return exchanges, nil
+	}
+	content, err := ioutil.ReadFile(recordingFile(ReplayDir, fqdn))
+	if os.IsNotExist(err) {
+		errorMsg := fmt.Sprintf("no recording of node %v in %v", fqdn, ReplayDir)
+		return nil, errors.New(errorMsg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	for {
+		exchange := &Exchange{}
+		err = decoder.Decode(exchange)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errorMsg := fmt.Sprintf("%v: %v", recordingFile(ReplayDir, fqdn), err)
+			return nil, errors.New(errorMsg)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	recordings[fqdn] = exchanges
+	return exchanges, nil
+}
+
+// An http transport serving the recorded responses of a node.
+// Repeated requests get the recorded responses in order, the last one
+// once all are served, so task polls replay the task states.
+type replayTransport struct {
+	node *Node
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	exchanges, err := loadRecording(t.node.Fqdn)
+	if err != nil {
+		return nil, err
+	}
+
+	url := exchangeUrl(req)
+	key := t.node.Fqdn + " " + req.Method + " " + url
+
+	recordingsMu.Lock()
+	var matching []*Exchange
+	for _, exchange := range exchanges {
+		if exchange.Method == req.Method && exchange.Url == url {
+			matching = append(matching, exchange)
+		}
+	}
+	if len(matching) == 0 {
+		recordingsMu.Unlock()
+		errorMsg := fmt.Sprintf("no recorded response for %v %v on node %v", req.Method, url, t.node.Fqdn)
+		return nil, errors.New(errorMsg)
+	}
+	cursor := replayCursors[key]
+	if cursor >= len(matching) {
+		cursor = len(matching) - 1
+	}
+	replayCursors[key] = cursor + 1
+	exchange := matching[cursor]
+	recordingsMu.Unlock()
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if exchange.Error != "" {
+		return nil, errors.New(exchange.Error)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%v %v", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode:    exchange.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        exchange.Header,
+		Body:          ioutil.NopCloser(strings.NewReader(exchange.ResponseBody)),
+		ContentLength: int64(len(exchange.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+// Get the transport talking to the node: simulated, replayed, recorded or direct
+func backendTransport(n *Node, transport http.RoundTripper) http.RoundTripper {
+	switch {
+	case ActiveSimulation != nil:
+		return &simulationTransport{simulation: ActiveSimulation, node: n}
+	case ReplayDir != "":
+		return &replayTransport{node: n}
+	case RecordDir != "":
+		return &recordTransport{next: transport, node: n}
+	}
+	return transport
+}
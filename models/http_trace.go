@@ -0,0 +1,157 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log the requests and responses of the pulp api clients, with or without bodies
+var DebugHttp bool
+var DebugHttpBodies bool
+
+// bodies are truncated in the logs
+const maxTraceBodySize = 64 * 1024
+
+const redacted = "[REDACTED]"
+
+var secretsMu sync.Mutex
+var secrets = make(map[string]bool)
+
+// Register a secret to be redacted from the http traces
+func RegisterSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets[secret] = true
+}
+
+// Replace the registered secrets in the text
+func Redact(text string) string {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	// longest first, a secret may contain another one
+	sorted := []string{}
+	for secret := range secrets {
+		sorted = append(sorted, secret)
+	}
+	sort.Sort(byLengthDesc(sorted))
+
+	for _, secret := range sorted {
+		text = strings.Replace(text, secret, redacted, -1)
+	}
+	return text
+}
+
+type byLengthDesc []string
+
+func (s byLengthDesc) Len() int           { return len(s) }
+func (s byLengthDesc) Less(i, j int) bool { return len(s[i]) > len(s[j]) }
+func (s byLengthDesc) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Get the url of the request without the password
+func redactUrl(req *http.Request) string {
+	copied := *req.URL
+	// the pulp client sets the path as opaque data, which hides the host
+	if strings.HasPrefix(copied.Opaque, "/") {
+		copied.Path = copied.Opaque
+		copied.Opaque = ""
+	}
+	if copied.User != nil {
+		copied.User = url.User(copied.User.Username())
+	}
+	return Redact(copied.String())
+}
+
+func redactHeaders(header http.Header) string {
+	keys := []string{}
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := []string{}
+	for _, key := range keys {
+		for _, value := range header[key] {
+			if strings.EqualFold(key, "Authorization") || strings.EqualFold(key, "Proxy-Authorization") {
+				// keep the scheme, e.g. Basic
+				if i := strings.Index(value, " "); i != -1 {
+					value = value[:i+1] + redacted
+				} else {
+					value = redacted
+				}
+			}
+			lines = append(lines, fmt.Sprintf("%v: %v", key, Redact(value)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func traceBody(body []byte) string {
+	if len(body) > maxTraceBodySize {
+		return Redact(string(body[:maxTraceBodySize])) + "...(truncated)"
+	}
+	return Redact(string(body))
+}
+
+// An http transport logging the exchanges of a node
+type traceTransport struct {
+	next   http.RoundTripper
+	node   *Node
+	bodies bool
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	entry := t.node.logger().
+		With("method", req.Method).
+		With("url", redactUrl(req)).
+		With("request_headers", redactHeaders(req.Header))
+
+	if t.bodies && req.Body != nil {
+		body, err := readBody(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		entry = entry.With("request_body", traceBody(body))
+	}
+
+	start := time.Now()
+	resp, err = t.next.RoundTrip(req)
+	entry = entry.With("latency", time.Since(start).String())
+	if err != nil {
+		entry.With("error", Redact(err.Error())).Infof("http request failed")
+		return resp, err
+	}
+
+	entry = entry.
+		With("status", resp.StatusCode).
+		With("response_headers", redactHeaders(resp.Header))
+	if t.bodies && resp.Body != nil {
+		body, err := readBody(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		entry = entry.With("response_body", traceBody(body))
+	}
+	entry.Infof("http exchange")
+	return
+}
+
+// Wrap the transport of the node with the http tracing if enabled
+func traceHttp(n *Node, transport http.RoundTripper) http.RoundTripper {
+	if !DebugHttp {
+		return transport
+	}
+	return &traceTransport{next: transport, node: n, bodies: DebugHttpBodies}
+}
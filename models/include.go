@@ -0,0 +1,234 @@
+package models
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Read the files matching the include patterns and merge them into the tree.
+// Relative patterns are resolved from baseDir.
+func (st *StageTree) IncludeFiles(baseDir string, patterns []string) (err error) {
+	err = st.includeFiles(baseDir, patterns, nil)
+	if err != nil {
+		return err
+	}
+	err = st.CheckDuplicateStages()
+	if err != nil {
+		return err
+	}
+	return st.ExpandTemplates()
+}
+
+// including holds the files being included, to detect include cycles
+func (st *StageTree) includeFiles(baseDir string, patterns []string, including []string) (err error) {
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(baseDir, pattern)
+		}
+
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			errorMsg := fmt.Sprintf("invalid include pattern '%v': %v", pattern, err)
+			return errors.New(errorMsg)
+		}
+
+		// a plain file name must exist, a glob may match nothing
+		if len(files) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			errorMsg := fmt.Sprintf("included file '%v' does not exist", pattern)
+			return errors.New(errorMsg)
+		}
+
+		for _, file := range files {
+			err = st.includeFile(file, including)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return
+}
+
+// Read a single file and merge its stages, tag settings and repository sets into the tree.
+// The files it includes are read as well, relative to its directory.
+func (st *StageTree) IncludeFile(file string) (err error) {
+	return st.includeFile(file, nil)
+}
+
+func (st *StageTree) includeFile(file string, including []string) (err error) {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	if posString(including, path) != -1 {
+		errorMsg := fmt.Sprintf("%v: include cycle %v -> %v", file, strings.Join(including, " -> "), path)
+		return errors.New(errorMsg)
+	}
+
+	v := viper.New()
+	v.SetConfigFile(file)
+	err = v.ReadInConfig()
+	if err != nil {
+		errorMsg := fmt.Sprintf("%v: %v", file, err)
+		return errors.New(errorMsg)
+	}
+
+	var included StageTree
+	err = DecodeStageTreeFile(file, v.AllSettings(), &included)
+	if err != nil {
+		return err
+	}
+
+	included.SetFile(file)
+	st.Stages = append(st.Stages, included.Stages...)
+
+	for tag, tagSetting := range included.TagSettings {
+		if _, exists := st.TagSettings[tag]; exists {
+			errorMsg := fmt.Sprintf("%v: duplicate settings for tag '%v'", file, tag)
+			return errors.New(errorMsg)
+		}
+		if st.TagSettings == nil {
+			st.TagSettings = make(map[string]*TagSetting)
+		}
+		st.TagSettings[tag] = tagSetting
+	}
+
+	for name, template := range included.Templates {
+		if _, exists := st.Templates[name]; exists {
+			errorMsg := fmt.Sprintf("%v: duplicate template '%v'", file, name)
+			return errors.New(errorMsg)
+		}
+		if st.Templates == nil {
+			st.Templates = make(map[string]interface{})
+		}
+		st.Templates[name] = template
+	}
+
+	for name, set := range included.RepositorySets {
+		if _, exists := st.RepositorySets[name]; exists {
+			errorMsg := fmt.Sprintf("%v: duplicate repository set '%v'", file, name)
+			return errors.New(errorMsg)
+		}
+		if st.RepositorySets == nil {
+			st.RepositorySets = make(map[string][]string)
+		}
+		st.RepositorySets[name] = set
+	}
+
+	for _, schedule := range included.Schedules {
+		if st.GetSchedule(schedule.Name) != nil {
+			errorMsg := fmt.Sprintf("%v: duplicate schedule '%v'", file, schedule.Name)
+			return errors.New(errorMsg)
+		}
+		st.Schedules = append(st.Schedules, schedule)
+	}
+
+	st.Notifications = append(st.Notifications, included.Notifications...)
+
+	if included.Simulation.isSet() {
+		if st.Simulation.isSet() {
+			errorMsg := fmt.Sprintf("%v: duplicate simulation settings", file)
+			return errors.New(errorMsg)
+		}
+		st.Simulation = included.Simulation
+	}
+
+	return st.includeFiles(filepath.Dir(file), included.Include, append(including, path))
+}
+
+// Decode the raw settings of the given configuration file into the stage tree.
+// Errors point at the file, and at the line of the stage failing to decode.
+func DecodeStageTreeFile(file string, settings map[string]interface{}, st *StageTree) (err error) {
+	err = DecodeStageTree(settings, st)
+	if err == nil {
+		return
+	}
+
+	// decode the stages one by one to find the failing one
+	stages, _ := settings["stages"].([]interface{})
+	occurrences := make(map[string]int)
+	for _, raw := range stages {
+		var stage Stage
+		name := ""
+		if fields, isMap := raw.(map[interface{}]interface{}); isMap {
+			name = fmt.Sprint(fields["name"])
+		}
+		line := findStageLine(file, name, occurrences[name])
+		occurrences[name]++
+
+		interpolated, stageErr := Interpolate(raw, nil)
+		if stageErr == nil {
+			stageErr = mapstructure.WeakDecode(interpolated, &stage)
+		}
+		if stageErr != nil && line > 0 {
+			errorMsg := fmt.Sprintf("%v:%v: stage '%v': %v", file, line, name, stageErr)
+			return errors.New(errorMsg)
+		}
+	}
+	errorMsg := fmt.Sprintf("%v: %v", file, err)
+	return errors.New(errorMsg)
+}
+
+// Record the file and line the stages of the tree are defined at
+func (st *StageTree) SetFile(file string) {
+	occurrences := make(map[string]int)
+	for _, stage := range st.Stages {
+		stage.File = file
+		stage.Line = findStageLine(file, stage.Name, occurrences[stage.Name])
+		occurrences[stage.Name]++
+	}
+}
+
+// Stage names must be unique over all files
+func (st *StageTree) CheckDuplicateStages() error {
+	seen := make(map[string]*Stage)
+	for _, stage := range st.Stages {
+		if first, exists := seen[stage.Name]; exists {
+			errorMsg := fmt.Sprintf("%v: duplicate stage '%v', already defined in %v",
+				stage.Location(),
+				stage.Name,
+				first.Location())
+			return errors.New(errorMsg)
+		}
+		seen[stage.Name] = stage
+	}
+	return nil
+}
+
+// Get the file and line the stage is defined at
+func (s *Stage) Location() string {
+	if s.Line == 0 {
+		return s.File
+	}
+	return fmt.Sprintf("%v:%v", s.File, s.Line)
+}
+
+// Find the line of the 'name:' key of the given stage, skipping the first
+// occurrences. Returns 0 if not found.
+func findStageLine(file string, name string, skip int) int {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	nameLine := regexp.MustCompile(`^\s*-?\s*name:\s*['"]?` + regexp.QuoteMeta(name) + `['"]?\s*(#.*)?$`)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if nameLine.MatchString(scanner.Text()) {
+			if skip == 0 {
+				return line
+			}
+			skip--
+		}
+	}
+	return 0
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Name     string            `xml:"name,attr"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Errors   int               `xml:"errors,attr"`
+	Skipped  int               `xml:"skipped,attr"`
+	Time     string            `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Errors    int              `xml:"errors,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	Time      string           `xml:"time,attr"`
+	Timestamp string           `xml:"timestamp,attr,omitempty"`
+	Cases     []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+func junitSeconds(start time.Time, end time.Time) string {
+	if start.IsZero() || end.IsZero() {
+		return "0.000"
+	}
+	return fmt.Sprintf("%.3f", end.Sub(start).Seconds())
+}
+
+// the size, throughput and remaining time of the sync, for the test case output
+func junitTransfer(result *RepositoryResult) string {
+	parts := []string{}
+	if result.SizeTotal > 0 {
+		parts = append(parts, fmt.Sprintf("size %v", FormatBytes(result.SizeTotal)))
+	}
+	if result.Throughput > 0 {
+		parts = append(parts, fmt.Sprintf("throughput %v", FormatThroughput(result.Throughput)))
+	}
+	if result.EtaSeconds > 0 {
+		parts = append(parts, fmt.Sprintf("eta %v", FormatSeconds(result.EtaSeconds)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Render the run as JUnit XML: a test suite per node, a test case per repository.
+// Repository errors are failures, skipped and not applicable repositories are
+// skipped cases and node errors are errors of a 'node' test case.
+func (r *Run) JUnit() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	suites := &junitTestSuites{
+		Name: fmt.Sprintf("nodetree %v %v", r.Command, r.Stage),
+		Time: junitSeconds(r.Start, r.End),
+	}
+
+	fqdns := []string{}
+	for fqdn := range r.Nodes {
+		fqdns = append(fqdns, fqdn)
+	}
+	sort.Strings(fqdns)
+
+	for _, fqdn := range fqdns {
+		ns := r.Nodes[fqdn]
+		suite := &junitTestSuite{Name: fqdn}
+		var start, end time.Time
+
+		for _, result := range r.Results {
+			if result.Fqdn != fqdn {
+				continue
+			}
+			if !result.Start.IsZero() && (start.IsZero() || result.Start.Before(start)) {
+				start = result.Start
+			}
+			if result.End.After(end) {
+				end = result.End
+			}
+
+			testCase := &junitTestCase{
+				Name:      result.Repository,
+				ClassName: r.Stage + "." + fqdn,
+				Time:      junitSeconds(result.Start, result.End),
+				SystemOut: junitTransfer(result),
+			}
+			switch result.State {
+			case "error":
+				testCase.Failure = &junitMessage{Message: result.Error, Text: result.Error}
+				suite.Failures++
+			case "skipped", "not applicable":
+				message := result.Message
+				if message == "" {
+					message = result.State
+				}
+				testCase.Skipped = &junitMessage{Message: message}
+				suite.Skipped++
+			}
+			suite.Cases = append(suite.Cases, testCase)
+			suite.Tests++
+		}
+
+		// repository errors without a result, e.g. of a check stopping early
+		for repository, e := range ns.RepositoryError {
+			if r.GetResult(fqdn, repository) != nil {
+				continue
+			}
+			suite.Cases = append(suite.Cases, &junitTestCase{
+				Name:      repository,
+				ClassName: r.Stage + "." + fqdn,
+				Time:      "0.000",
+				Failure:   &junitMessage{Message: e, Text: e},
+			})
+			suite.Failures++
+			suite.Tests++
+		}
+
+		// CI servers only show errors of test cases
+		if len(ns.Errors) > 0 {
+			suite.Cases = append(suite.Cases, &junitTestCase{
+				Name:      "node",
+				ClassName: r.Stage + "." + fqdn,
+				Time:      "0.000",
+				Error:     &junitMessage{Message: ns.Errors[0], Text: strings.Join(ns.Errors, "\n")},
+			})
+			suite.Errors++
+			suite.Tests++
+		}
+
+		if suite.Tests == 0 && suite.Errors == 0 {
+			continue
+		}
+		suite.Time = junitSeconds(start, end)
+		if !start.IsZero() {
+			suite.Timestamp = start.Format("2006-01-02T15:04:05")
+		}
+
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Skipped += suite.Skipped
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	content, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(content, '\n')...), nil
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestRunJUnit(t *testing.T) {
+	content, err := newTestRun().JUnit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(content, &suites); err != nil {
+		t.Fatalf("invalid xml: %v\n%s", err, content)
+	}
+
+	if suites.Tests != 8 || suites.Failures != 2 || suites.Errors != 1 || suites.Skipped != 3 {
+		t.Errorf("got %v tests, %v failures, %v errors, %v skipped, want 8, 2, 1, 3",
+			suites.Tests, suites.Failures, suites.Errors, suites.Skipped)
+	}
+
+	// the root node has no results, it has no suite
+	tests := []struct {
+		name     string
+		tests    int
+		failures int
+		errors   int
+		skipped  int
+		time     string
+	}{
+		// the failed repository
+		{"dc1", 2, 1, 0, 0, "5.000"},
+		// the not applicable repository and the node error
+		{"dc2", 3, 0, 1, 1, "8.000"},
+		// the repository error without a result
+		{"dmz1", 3, 1, 0, 2, "0.000"},
+	}
+	if len(suites.Suites) != len(tests) {
+		t.Fatalf("got %v suites, want %v", len(suites.Suites), len(tests))
+	}
+	for i, test := range tests {
+		suite := suites.Suites[i]
+		if suite.Name != test.name {
+			t.Errorf("suite %v: got %v, want %v", i, suite.Name, test.name)
+			continue
+		}
+		if suite.Tests != test.tests || suite.Failures != test.failures ||
+			suite.Errors != test.errors || suite.Skipped != test.skipped {
+			t.Errorf("%v: got %v tests, %v failures, %v errors, %v skipped, want %v, %v, %v, %v",
+				test.name, suite.Tests, suite.Failures, suite.Errors, suite.Skipped,
+				test.tests, test.failures, test.errors, test.skipped)
+		}
+		if suite.Time != test.time {
+			t.Errorf("%v: got time %v, want %v", test.name, suite.Time, test.time)
+		}
+	}
+
+	failure := suites.Suites[0].Cases[1].Failure
+	if failure == nil || failure.Message != "<script>alert('x')</script>" {
+		t.Errorf("got failure %+v, want the error of the repository", failure)
+	}
+}
@@ -2,26 +2,36 @@ package models
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"github.com/msutter/go-pulp/pulp"
+	"github.com/msutter/nodetree/log"
 	"net/url"
 	"strings"
+	"time"
 )
 
 type Node struct {
-	Fqdn            string
-	ApiUser         string
-	ApiPasswd       string
-	Tags            []string
-	Parent          *Node
-	Children        []*Node
-	Repositories    []Repository
-	SyncPath        []string
-	Depth           int
-	TreePosition    int
-	Errors          []error
-	RepositoryError map[string]error
+	Fqdn             string           `yaml:"fqdn"`
+	ApiUser          string           `yaml:"apiuser,omitempty"`
+	ApiPasswd        string           `yaml:"apipasswd,omitempty"`
+	Tags             []string         `yaml:"tags,omitempty"`
+	Parent           *Node            `yaml:"-"`
+	Children         []*Node          `yaml:"children,omitempty"`
+	Repositories     []Repository     `mapstructure:"-" yaml:"-"`
+	RepositoryFilter RepositoryFilter `mapstructure:"repositories" yaml:"repositories,omitempty"`
+	SyncPath         []string         `yaml:"-"`
+	Depth            int              `yaml:"-"`
+	TreePosition     int              `yaml:"-"`
+	Errors           []error          `yaml:"-"`
+	RepositoryError  map[string]error `yaml:"-"`
+	TagSettings      []*TagSetting    `mapstructure:"-" yaml:"-"`
+	Reachable        bool             `mapstructure:"-" yaml:"-"`
+	ApiLatency       time.Duration    `mapstructure:"-" yaml:"-"`
+	Status           *PulpStatus      `mapstructure:"-" yaml:"-"`
+	PreflightFailed  bool             `mapstructure:"-" yaml:"-"`
+	preflight        *preflightFailure
+	syncStates       map[string]string
+	policy           *FailurePolicy
 }
 
 // Matches the given fqdn?
@@ -57,6 +67,40 @@ func (n *Node) ContainsTag(tag string) bool {
 	return ret
 }
 
+// Add the given tags if not yet present
+func (n *Node) InheritTags(tags []string) {
+	for _, tag := range tags {
+		if !n.ContainsTag(tag) {
+			n.Tags = append(n.Tags, tag)
+		}
+	}
+}
+
+// Get the settings of the node, merged from the settings of its tags
+func (n *Node) Settings() (settings TagSetting) {
+	for _, tagSetting := range n.TagSettings {
+		settings.Merge(tagSetting)
+	}
+	return
+}
+
+// Is the repository carried by the node?
+// The include/exclude lists of the node, its tags and its ancestors must all allow it.
+func (n *Node) RepositoryApplies(repository string) bool {
+	if !n.RepositoryFilter.Allows(repository) {
+		return false
+	}
+	for _, tagSetting := range n.TagSettings {
+		if !tagSetting.Repositories.Allows(repository) {
+			return false
+		}
+	}
+	if !n.IsRoot() {
+		return n.Parent.RepositoryApplies(repository)
+	}
+	return true
+}
+
 // Contains the given tags?
 func (n *Node) ContainsTags(tags []string) bool {
 	ret := false
@@ -68,6 +112,28 @@ func (n *Node) ContainsTags(tags []string) bool {
 	return ret
 }
 
+// Get a log entry with the node fields
+func (n *Node) logger() *log.Entry {
+	return log.WithFields(log.Fields{"fqdn": n.Fqdn})
+}
+
+// Copy the node configuration and its children, without the state of previous runs
+func (n *Node) Copy() *Node {
+	copied := &Node{
+		Fqdn:             n.Fqdn,
+		ApiUser:          n.ApiUser,
+		ApiPasswd:        n.ApiPasswd,
+		Tags:             append([]string{}, n.Tags...),
+		RepositoryFilter: n.RepositoryFilter,
+	}
+	for _, child := range n.Children {
+		copiedChild := child.Copy()
+		copiedChild.Parent = copied
+		copied.Children = append(copied.Children, copiedChild)
+	}
+	return copied
+}
+
 // Is a Leaf?
 func (n *Node) IsLeaf() bool {
 	if len(n.Children) == 0 {
@@ -86,6 +152,13 @@ func (n *Node) IsRoot() bool {
 	}
 }
 
+func (n *Node) parentFqdn() string {
+	if n.IsRoot() {
+		return ""
+	}
+	return n.Parent.Fqdn
+}
+
 func (n *Node) AncestorTreeWalker(f func(*Node)) {
 	parent := n.Parent
 	if parent != nil {
@@ -134,6 +207,19 @@ func (n *Node) GetAncestorByDepth(depth int) (depthAncestor *Node) {
 	return
 }
 
+// Get the node itself or the ancestor which failed the preflight, if any
+func (n *Node) PreflightFailedNode() (failed *Node) {
+	if n.preflight != nil {
+		return n
+	}
+	n.AncestorTreeWalker(func(ancestor *Node) {
+		if ancestor.preflight != nil && failed == nil {
+			failed = ancestor
+		}
+	})
+	return
+}
+
 // Has Error
 func (n *Node) HasError() bool {
 	returnValue := false
@@ -265,6 +351,23 @@ func (n *Node) TagsInDescendant(childTags []string) bool {
 }
 
 func (n *Node) Sync(repositories []string, progressChannel chan SyncProgress) (err error) {
+	// skip the subtrees of nodes which failed the preflight
+	if failed := n.PreflightFailedNode(); failed != nil {
+		// the node keeps the errors found by the preflight
+		n.preflight.restore(n)
+		if !n.IsRoot() {
+			for _, repository := range repositories {
+				n.sendProgress(progressChannel, SyncProgress{
+					Repository: repository,
+					Node:       n,
+					State:      "skipped",
+					Message:    fmt.Sprintf("skipping sync due to failed preflight on node %v", failed.Fqdn),
+				})
+			}
+		}
+		return
+	}
+
 	client, err := PulpApiClient(n)
 	err = PulpApiSyncRepo(n, client, repositories, progressChannel)
 	if err != nil {
@@ -283,12 +386,14 @@ func (n *Node) CheckRepositories(repositories []string) (err error) {
 		fmt.Printf("checking repositories on node %v\n", n.Fqdn)
 		for _, targetRepository := range repositories {
 			fmt.Printf("  - '%v': ", targetRepository)
-			if !n.HasRepository(targetRepository) {
+			if !n.RepositoryApplies(targetRepository) {
+				fmt.Printf("not applicable\n")
+				continue
+			}
+			err = n.CheckRepository(targetRepository)
+			if err != nil {
 				fmt.Printf("error\n")
 				fmt.Printf("\n")
-				errorMsg := fmt.Sprintf("Could not find repository '%v' on node %v", targetRepository, n.Fqdn)
-				err = errors.New(errorMsg)
-				n.RepositoryError[targetRepository] = err
 				return err
 			} else {
 				fmt.Printf("pass\n")
@@ -300,35 +405,77 @@ func (n *Node) CheckRepositories(repositories []string) (err error) {
 	return
 }
 
+// Check that the repository exists on the node
+func (n *Node) CheckRepository(repository string) (err error) {
+	if !n.HasRepository(repository) {
+		errorMsg := fmt.Sprintf("Could not find repository '%v' on node %v", repository, n.Fqdn)
+		err = newKindError(MissingRepositoryError, errorMsg)
+		n.RepositoryError[repository] = err
+	}
+	return
+}
+
 func (n *Node) CheckRepositoryFeeds() (err error) {
 	if !n.IsRoot() {
 		for _, currentRepository := range n.Repositories {
-			u, err := url.Parse(currentRepository.Feed)
-
-			// check that the feed is pointing on the parent node
-			if u.Host != n.Parent.Fqdn {
-				errorMsg := fmt.Sprintf("Repository '%v' has invalid feed '%v'. Parent is '%v'",
-					currentRepository.Name,
-					currentRepository.Feed,
-					n.Parent.Fqdn)
+			// repositories not carried on purpose are not checked
+			if !n.RepositoryApplies(currentRepository.Name) {
+				continue
+			}
 
-				err = errors.New(errorMsg)
-				n.RepositoryError[currentRepository.Name] = err
+			err = n.CheckRepositoryFeed(currentRepository)
+			if err != nil {
 				return err
 			}
+		}
+	}
+	return
+}
 
-			// check that the feed is pointing on an existing repository on the parent node
-			pathSlice := strings.Split(u.Path, "/")
-			repoInPath := pathSlice[len(pathSlice)-2]
+// Check the feed of a single repository
+func (n *Node) CheckRepositoryFeed(currentRepository Repository) (err error) {
+	u, err := url.Parse(currentRepository.Feed)
+	if err != nil {
+		err = newKindError(FeedError, err.Error())
+		n.RepositoryError[currentRepository.Name] = err
+		return err
+	}
 
-			if !n.Parent.HasRepository(repoInPath) {
-				errorMsg := fmt.Sprintf("Repository '%v' does not exist on parent node '%v'",
-					repoInPath,
-					n.Parent.Fqdn)
-				err = errors.New(errorMsg)
-				n.RepositoryError[currentRepository.Name] = err
-				return err
-			}
+	// check that the feed is pointing on the parent node
+	if u.Host != n.Parent.Fqdn {
+		errorMsg := fmt.Sprintf("Repository '%v' has invalid feed '%v'. Parent is '%v'",
+			currentRepository.Name,
+			currentRepository.Feed,
+			n.Parent.Fqdn)
+
+		err = newKindError(FeedError, errorMsg)
+		n.RepositoryError[currentRepository.Name] = err
+		return err
+	}
+
+	// check that the feed is pointing on an existing repository on the parent node
+	pathSlice := strings.Split(u.Path, "/")
+	repoInPath := ""
+	if len(pathSlice) >= 2 {
+		repoInPath = pathSlice[len(pathSlice)-2]
+	}
+
+	if !n.Parent.HasRepository(repoInPath) {
+		errorMsg := fmt.Sprintf("Repository '%v' does not exist on parent node '%v'",
+			repoInPath,
+			n.Parent.Fqdn)
+		err = newKindError(MissingParentRepositoryError, errorMsg)
+		n.RepositoryError[currentRepository.Name] = err
+		return err
+	}
+	return
+}
+
+// Get a repository of the node by name
+func (n *Node) GetRepository(repository string) (outRepository *Repository) {
+	for i, currentRepository := range n.Repositories {
+		if currentRepository.Name == repository {
+			outRepository = &n.Repositories[i]
 		}
 	}
 	return
@@ -344,31 +491,70 @@ func (n *Node) HasRepository(repository string) bool {
 }
 
 func (n *Node) UpdateRepositories() (err error) {
-	client, err := PulpApiClient(n)
+	repositories, err := n.GetRemoteRepositories()
 	if err != nil {
 		n.Errors = append(n.Errors, err)
 		return err
 	}
+	n.Repositories = append(n.Repositories, repositories...)
+	return
+}
+
+// Get the repositories existing on the pulp node
+func (n *Node) GetRemoteRepositories() (repositories []Repository, err error) {
+	client, err := PulpApiClient(n)
+	if err != nil {
+		return repositories, err
+	}
 
 	var remoteRepos []*pulp.Repository
 	remoteRepos, err = PulpApiGetRepos(n, client)
-
 	if err != nil {
-		n.Errors = append(n.Errors, err)
-		return err
+		return repositories, err
 	}
 
 	for _, remoteRepo := range remoteRepos {
 		repo := Repository{
 			Name: remoteRepo.Id,
-			Feed: remoteRepo.Importers[0].ImporterConfig.Feed,
 		}
-		n.Repositories = append(n.Repositories, repo)
+		if len(remoteRepo.Importers) > 0 && remoteRepo.Importers[0].ImporterConfig != nil {
+			repo.Feed = remoteRepo.Importers[0].ImporterConfig.Feed
+		}
+		repositories = append(repositories, repo)
 	}
 
 	return
 }
 
+// Query the server status. Unhealthy states are recorded as node errors.
+func (n *Node) UpdateHealth() (err error) {
+	client, err := PulpApiClient(n)
+	if err != nil {
+		n.Errors = append(n.Errors, err)
+		return err
+	}
+
+	start := time.Now()
+	n.Status, err = PulpApiGetStatus(n, client)
+	n.ApiLatency = time.Since(start)
+	n.Reachable = err == nil
+	if err != nil {
+		n.Errors = append(n.Errors, err)
+		return err
+	}
+
+	if !n.Status.DatabaseConnection.Connected {
+		n.Errors = append(n.Errors, newKindError(DatabaseError, "database is not connected"))
+	}
+	if !n.Status.MessagingConnection.Connected {
+		n.Errors = append(n.Errors, newKindError(MessagingError, "messaging is not connected"))
+	}
+	if n.Status.LiveWorkers() == 0 {
+		n.Errors = append(n.Errors, newKindError(WorkersError, "no live workers"))
+	}
+	return
+}
+
 func (n *Node) GetTreeRaw(msg string) (treeRaw string) {
 	var buffer bytes.Buffer
 	if n.Depth == 0 {
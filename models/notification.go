@@ -0,0 +1,27 @@
+package models
+
+// A webhook notified at the end of runs
+type Notification struct {
+	Url     string   `yaml:"url"`
+	Secret  string   `yaml:"secret,omitempty"`
+	Events  []string `yaml:"events,omitempty"`
+	Timeout int      `yaml:"timeout,omitempty"`
+	// unset is the default number of retries, 0 disables the retries
+	Retries *int `yaml:"retries,omitempty"`
+}
+
+// The notification events
+var NotificationEvents = []string{"run_finished", "run_failed", "node_failed"}
+
+// Is the notification sent on the event? All events by default.
+func (n *Notification) Wants(event string) bool {
+	if len(n.Events) == 0 {
+		return true
+	}
+	for _, e := range n.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// On preflight failures: 'abort' the sync or 'skip' the failing subtrees
+var PreflightModes = []string{"abort", "skip"}
+
+// Run the preflight checks if the stage enables them. In the 'abort' mode
+// failures return an error and the sync must not start, in the 'skip' mode
+// the sync skips the subtrees of the failing nodes.
+func (s *Stage) RunPreflight(repositories []string) (failed []*Node, err error) {
+	mode := s.PreflightMode
+	if mode == "" {
+		mode = "abort"
+	}
+	if posString(PreflightModes, mode) == -1 {
+		errorMsg := fmt.Sprintf("unknown preflight mode '%v', valid modes are %v", mode, PreflightModes)
+		return nil, errors.New(errorMsg)
+	}
+	if !s.Preflight {
+		return
+	}
+
+	failed = s.PreflightCheck(repositories)
+	if len(failed) > 0 && mode == "abort" {
+		errorMsg := fmt.Sprintf("preflight failed on %v node(s), sync aborted", len(failed))
+		err = errors.New(errorMsg)
+	}
+	return
+}
+
+// Check before syncing that every node accepts authentication, has live workers
+// and carries every requested repository with a correct feed.
+// Returns the nodes failing the checks, their errors are recorded on the nodes.
+func (s *Stage) PreflightCheck(repositories []string) (failed []*Node) {
+	s.ConcurrentNodeWalker(func(n *Node) {
+		n.preflight = nil
+		// status api: reachability and live workers
+		err := n.UpdateHealth()
+		if err != nil {
+			return
+		}
+		// repository listing: authentication
+		n.UpdateRepositories()
+	})
+
+	// the repositories are checked once all nodes are queried, feeds need the parent repositories
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		if !n.HasError() {
+			n.preflightRepositories(repositories)
+		}
+		if n.HasError() {
+			n.failPreflight()
+			failed = append(failed, n)
+		}
+	})
+	return
+}
+
+func (n *Node) preflightRepositories(repositories []string) {
+	for _, repository := range repositories {
+		if !n.RepositoryApplies(repository) {
+			continue
+		}
+		if n.CheckRepository(repository) != nil {
+			continue
+		}
+		// the feed is only checked against a parent which passed the preflight
+		if !n.IsRoot() && !n.Parent.PreflightFailed {
+			n.CheckRepositoryFeed(*n.GetRepository(repository))
+		}
+	}
+}
+
+// The errors of a node failing the preflight. They are kept apart from the
+// node state, the sync restores them after initializing the stage.
+type preflightFailure struct {
+	errors           []error
+	repositoryErrors map[string]error
+}
+
+// Mark the node as failing the preflight
+func (n *Node) failPreflight() {
+	n.PreflightFailed = true
+	n.preflight = &preflightFailure{
+		errors:           append([]error{}, n.Errors...),
+		repositoryErrors: make(map[string]error),
+	}
+	for repository, err := range n.RepositoryError {
+		n.preflight.repositoryErrors[repository] = err
+	}
+}
+
+// Restore the preflight state of the node, nothing if it passed the preflight
+func (pf *preflightFailure) restore(n *Node) {
+	if pf == nil {
+		return
+	}
+	n.PreflightFailed = true
+	n.Errors = append(n.Errors, pf.errors...)
+	for repository, err := range pf.repositoryErrors {
+		n.RepositoryError[repository] = err
+	}
+}
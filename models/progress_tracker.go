@@ -0,0 +1,268 @@
+package models
+
+import (
+	"time"
+)
+
+// weight of the latest sample in the smoothed throughputs
+const throughputSmoothing = 0.3
+
+// the stage eta walks the whole tree, it is recomputed at most once per interval
+const stageEtaInterval = time.Second
+
+// Tracks the progress reports of the repositories on the nodes of a stage over
+// time, to estimate the throughputs and the remaining times.
+// Not safe for concurrent use, the reports are passed in one at a time.
+type ProgressTracker struct {
+	stage        *Stage
+	repositories []string
+	progress     map[string]*repositoryProgress
+	// the largest size reported for each repository
+	sizes map[string]int
+
+	// the mean throughput of each node and of all nodes, recomputed when a throughput changed
+	nodeThroughputs  map[string]float64
+	meanThroughput   float64
+	throughputsStale bool
+
+	stageEta   time.Duration
+	stageEtaAt time.Time
+
+	// the time of the reports
+	now func() time.Time
+}
+
+type repositoryProgress struct {
+	fqdn          string
+	state         string
+	start         time.Time
+	last          time.Time
+	sizeTotal     int
+	sizeLeft      int
+	itemsLeft     int
+	throughput    float64
+	itemsRate     float64
+	eta           time.Duration
+	hasThroughput bool
+}
+
+func NewProgressTracker(s *Stage, repositories []string) *ProgressTracker {
+	return &ProgressTracker{
+		stage:        s,
+		repositories: repositories,
+		progress:     make(map[string]*repositoryProgress),
+		sizes:        make(map[string]int),
+		now:          time.Now,
+	}
+}
+
+func progressKey(fqdn string, repository string) string {
+	return fqdn + "/" + repository
+}
+
+// Record the progress report and set its throughput and remaining times
+func (pt *ProgressTracker) Update(sp *SyncProgress) {
+	key := progressKey(sp.Node.Fqdn, sp.Repository)
+	rp, exists := pt.progress[key]
+	if !exists {
+		rp = &repositoryProgress{fqdn: sp.Node.Fqdn}
+		pt.progress[key] = rp
+	}
+
+	now := pt.now()
+	throughput := rp.throughput
+	switch sp.State {
+	case "running":
+		if sp.SizeTotal > pt.sizes[sp.Repository] {
+			pt.sizes[sp.Repository] = sp.SizeTotal
+		}
+		if rp.state == "running" {
+			rp.sample(now, sp)
+		} else {
+			rp.start = now
+		}
+		rp.last = now
+		rp.sizeTotal = sp.SizeTotal
+		rp.sizeLeft = sp.SizeLeft
+		rp.itemsLeft = sp.ItemsLeft
+		rp.estimate()
+	case "finished":
+		if sp.SizeTotal > 0 {
+			rp.sizeTotal = sp.SizeTotal
+			if sp.SizeTotal > pt.sizes[sp.Repository] {
+				pt.sizes[sp.Repository] = sp.SizeTotal
+			}
+		}
+		// the average over the whole sync
+		if !rp.start.IsZero() && rp.sizeTotal > 0 {
+			if seconds := now.Sub(rp.start).Seconds(); seconds > 0 {
+				rp.throughput = float64(rp.sizeTotal) / seconds
+				rp.hasThroughput = true
+			}
+		}
+		rp.eta = 0
+	default:
+		rp.eta = 0
+	}
+	rp.state = sp.State
+	if rp.throughput != throughput {
+		pt.throughputsStale = true
+	}
+
+	sp.Throughput = rp.throughput
+	sp.Eta = rp.eta
+	if now.Sub(pt.stageEtaAt) >= stageEtaInterval {
+		pt.stageEta = pt.StageEta()
+		pt.stageEtaAt = now
+	}
+	sp.StageEta = pt.stageEta
+}
+
+// smooth the throughputs with the rates since the previous report
+func (rp *repositoryProgress) sample(now time.Time, sp *SyncProgress) {
+	seconds := now.Sub(rp.last).Seconds()
+	if seconds <= 0 {
+		return
+	}
+	rate := float64(rp.sizeLeft-sp.SizeLeft) / seconds
+	itemsRate := float64(rp.itemsLeft-sp.ItemsLeft) / seconds
+	if rate < 0 || itemsRate < 0 {
+		// the task restarted a phase, the previous values do not apply
+		return
+	}
+	if !rp.hasThroughput {
+		rp.throughput = rate
+		rp.itemsRate = itemsRate
+		rp.hasThroughput = true
+		return
+	}
+	rp.throughput = throughputSmoothing*rate + (1-throughputSmoothing)*rp.throughput
+	rp.itemsRate = throughputSmoothing*itemsRate + (1-throughputSmoothing)*rp.itemsRate
+}
+
+func (rp *repositoryProgress) estimate() {
+	switch {
+	case rp.sizeTotal > 0 && rp.throughput > 0:
+		rp.eta = time.Duration(float64(rp.sizeLeft) / rp.throughput * float64(time.Second))
+	case rp.itemsLeft > 0 && rp.itemsRate > 0:
+		rp.eta = time.Duration(float64(rp.itemsLeft) / rp.itemsRate * float64(time.Second))
+	default:
+		rp.eta = 0
+	}
+}
+
+func (rp *repositoryProgress) isDone() bool {
+	switch rp.state {
+	case "finished", "error", "skipped", "not applicable":
+		return true
+	}
+	return false
+}
+
+// Get the throughput of the node in bytes per second,
+// the mean of all nodes if the node has none yet
+func (pt *ProgressTracker) nodeThroughput(fqdn string) float64 {
+	if pt.throughputsStale || pt.nodeThroughputs == nil {
+		pt.computeThroughputs()
+	}
+	if throughput, exists := pt.nodeThroughputs[fqdn]; exists {
+		return throughput
+	}
+	return pt.meanThroughput
+}
+
+func (pt *ProgressTracker) computeThroughputs() {
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	var sum float64
+	var count int
+	for _, rp := range pt.progress {
+		if !rp.hasThroughput || rp.throughput <= 0 {
+			continue
+		}
+		sum += rp.throughput
+		count++
+		sums[rp.fqdn] += rp.throughput
+		counts[rp.fqdn]++
+	}
+
+	pt.nodeThroughputs = make(map[string]float64)
+	for fqdn, nodeSum := range sums {
+		pt.nodeThroughputs[fqdn] = nodeSum / float64(counts[fqdn])
+	}
+	pt.meanThroughput = 0
+	if count > 0 {
+		pt.meanThroughput = sum / float64(count)
+	}
+	pt.throughputsStale = false
+}
+
+// Will the repository be skipped on the node due to an ancestor?
+func (pt *ProgressTracker) ancestorFailed(n *Node, repository string) bool {
+	for ancestor := n.Parent; ancestor != nil; ancestor = ancestor.Parent {
+		if rp, exists := pt.progress[progressKey(ancestor.Fqdn, repository)]; exists {
+			if rp.state == "error" || rp.state == "skipped" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Get the remaining time of the syncs on the node, without its descendants
+func (pt *ProgressTracker) nodeRemaining(n *Node) (remaining time.Duration, known bool) {
+	known = true
+	if n.IsRoot() {
+		// the root node is the source, it does not sync
+		return
+	}
+	for _, repository := range pt.repositories {
+		if !n.RepositoryApplies(repository) || pt.ancestorFailed(n, repository) {
+			continue
+		}
+		rp, exists := pt.progress[progressKey(n.Fqdn, repository)]
+		if exists && rp.isDone() {
+			continue
+		}
+		if exists && rp.state == "running" && rp.eta > 0 {
+			remaining += rp.eta
+			continue
+		}
+
+		// not started yet, from the size seen on other nodes
+		size := pt.sizes[repository]
+		throughput := pt.nodeThroughput(n.Fqdn)
+		if size == 0 || throughput <= 0 {
+			known = false
+			continue
+		}
+		remaining += time.Duration(float64(size) / throughput * float64(time.Second))
+	}
+	return
+}
+
+// Get the remaining time of the subtree: the node syncs, then its children in parallel
+func (pt *ProgressTracker) subtreeRemaining(n *Node) (remaining time.Duration, known bool) {
+	remaining, known = pt.nodeRemaining(n)
+	var longest time.Duration
+	for _, child := range n.Children {
+		childRemaining, childKnown := pt.subtreeRemaining(child)
+		known = known && childKnown
+		if childRemaining > longest {
+			longest = childRemaining
+		}
+	}
+	return remaining + longest, known
+}
+
+// Get the estimated remaining time of the whole stage, 0 if unknown
+func (pt *ProgressTracker) StageEta() time.Duration {
+	if pt.stage.PulpRootNode == nil {
+		return 0
+	}
+	remaining, known := pt.subtreeRemaining(pt.stage.PulpRootNode)
+	if !known {
+		return 0
+	}
+	return remaining
+}
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// a progress report at the given second of the sync
+type testReport struct {
+	second     int
+	fqdn       string
+	repository string
+	state      string
+	sizeTotal  int
+	sizeLeft   int
+}
+
+// pass the reports to a tracker of the test stage, with the clock at their seconds
+func trackReports(s *Stage, repositories []string, reports []testReport) (pt *ProgressTracker, updated []SyncProgress) {
+	start := time.Date(2016, 11, 4, 2, 0, 0, 0, time.UTC)
+	var now time.Time
+	pt = NewProgressTracker(s, repositories)
+	pt.now = func() time.Time { return now }
+	for _, report := range reports {
+		now = start.Add(time.Duration(report.second) * time.Second)
+		sp := SyncProgress{
+			Node:       s.GetNodeByFqdn(report.fqdn),
+			Repository: report.repository,
+			State:      report.state,
+			SizeTotal:  report.sizeTotal,
+			SizeLeft:   report.sizeLeft,
+		}
+		pt.Update(&sp)
+		updated = append(updated, sp)
+	}
+	return
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func closeTo(got time.Duration, want time.Duration) bool {
+	return math.Abs(float64(got-want)) < float64(time.Millisecond)
+}
+
+func TestProgressTrackerThroughput(t *testing.T) {
+	reports := []testReport{
+		{0, "dc1", "rhel7-os", "running", 1000, 1000},
+		{1, "dc1", "rhel7-os", "running", 1000, 800},
+		{2, "dc1", "rhel7-os", "running", 1000, 500},
+		{4, "dc1", "rhel7-os", "finished", 1000, 0},
+	}
+	want := []struct {
+		throughput float64
+		eta        time.Duration
+	}{
+		// no rate yet
+		{0, 0},
+		{200, 4 * time.Second},
+		// smoothed: 0.3 * 300 + 0.7 * 200
+		{230, seconds(500.0 / 230)},
+		// the average over the whole sync
+		{250, 0},
+	}
+
+	_, updated := trackReports(newTestStage(), []string{"rhel7-os"}, reports)
+	for i, sp := range updated {
+		if math.Abs(sp.Throughput-want[i].throughput) > 0.001 {
+			t.Errorf("report %v: got throughput %v, want %v", i, sp.Throughput, want[i].throughput)
+		}
+		if !closeTo(sp.Eta, want[i].eta) {
+			t.Errorf("report %v: got eta %v, want %v", i, sp.Eta, want[i].eta)
+		}
+	}
+}
+
+func TestProgressTrackerStageEta(t *testing.T) {
+	// dc2 syncs 1000 bytes in 2 seconds
+	dc2Finished := []testReport{
+		{0, "dc2", "rhel7-os", "running", 1000, 1000},
+		{2, "dc2", "rhel7-os", "finished", 1000, 0},
+	}
+	tests := []struct {
+		name    string
+		reports []testReport
+		eta     time.Duration
+	}{
+		{
+			// dc1 needs 4 more seconds at 200 bytes per second, then dmz1 syncs
+			// 1000 bytes at the mean throughput of dc1 and dc2, 350 bytes per second
+			"running and pending",
+			append(dc2Finished,
+				testReport{0, "dc1", "rhel7-os", "running", 1000, 1000},
+				testReport{1, "dc1", "rhel7-os", "running", 1000, 800}),
+			4*time.Second + seconds(1000.0/350),
+		},
+		{
+			// dmz1 skips the repository failed on dc1, dc2 needs 4 more seconds
+			"ancestor failed",
+			[]testReport{
+				{0, "dc1", "rhel7-os", "error", 0, 0},
+				{0, "dc2", "rhel7-os", "running", 1000, 1000},
+				{1, "dc2", "rhel7-os", "running", 1000, 800},
+			},
+			4 * time.Second,
+		},
+		{
+			// the pending repositories have no known size
+			"unknown",
+			[]testReport{
+				{0, "dc1", "rhel7-os", "running", 0, 0},
+			},
+			0,
+		},
+	}
+	for _, test := range tests {
+		pt, _ := trackReports(newTestStage(), []string{"rhel7-os"}, test.reports)
+		if eta := pt.StageEta(); !closeTo(eta, test.eta) {
+			t.Errorf("%v: got stage eta %v, want %v", test.name, eta, test.eta)
+		}
+	}
+}
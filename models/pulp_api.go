@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/msutter/go-pulp/pulp"
 	"github.com/spf13/viper"
+	"net/http"
+	"strings"
 	"time"
 )
 
@@ -66,8 +68,15 @@ func NodeContainsRepo(pulpRepos []*pulp.Repository, repository string) bool {
 // }
 
 func PulpApiClient(n *Node) (client *pulp.Client, err error) {
+	settings := n.Settings()
 
-	// Use default credentials if not specified on node level
+	// Use tag credentials, then default credentials if not specified on node level
+	if n.ApiUser == "" {
+		n.ApiUser = settings.ApiUser
+	}
+	if n.ApiPasswd == "" {
+		n.ApiPasswd = settings.ApiPasswd
+	}
 	if n.ApiUser == "" {
 		n.ApiUser = viper.GetString("ApiUser")
 	}
@@ -76,10 +85,26 @@ func PulpApiClient(n *Node) (client *pulp.Client, err error) {
 	}
 
 	// create the API client
-	client, err = pulp.NewClient(n.Fqdn, n.ApiUser, n.ApiPasswd, nil)
+	httpClient := &http.Client{}
+	client, err = pulp.NewClient(n.Fqdn, n.ApiUser, n.ApiPasswd, httpClient)
 	if err != nil {
 		return client, err
 	}
+
+	// apply the timeouts and tls settings of the tags
+	transport, err := settings.Transport()
+	if err != nil {
+		return client, err
+	}
+	httpClient.Transport = traceHttp(n, backendTransport(n, transport))
+	RegisterSecret(n.ApiPasswd)
+
+	if settings.Ssl {
+		baseURL := client.BaseURL()
+		baseURL.Scheme = "https"
+		err = client.SetBaseURL(baseURL.String())
+	}
+	n.logger().With("url", client.BaseURL().String()).With("user", n.ApiUser).Tracef("api client created")
 	return
 }
 
@@ -93,12 +118,81 @@ func PulpApiGetRepos(n *Node, client *pulp.Client) (repos []*pulp.Repository, er
 
 	repos, _, err = client.Repositories.ListRepositories(opt)
 	if err != nil {
+		n.logger().With("error", err).Errorf("listing repositories failed")
 		return repos, err
 	}
 
+	n.logger().With("count", len(repos)).Infof("repositories listed")
 	return repos, err
 }
 
+// Pulp server status, as returned by the status api
+type PulpStatus struct {
+	ApiVersion string `json:"api_version"`
+	Versions   struct {
+		PlatformVersion string `json:"platform_version"`
+	} `json:"versions"`
+	DatabaseConnection struct {
+		Connected bool `json:"connected"`
+	} `json:"database_connection"`
+	MessagingConnection struct {
+		Connected bool `json:"connected"`
+	} `json:"messaging_connection"`
+	KnownWorkers []struct {
+		Id            string `json:"_id"`
+		LastHeartbeat string `json:"last_heartbeat"`
+	} `json:"known_workers"`
+}
+
+// Count the workers able to run sync tasks
+func (ps *PulpStatus) LiveWorkers() (count int) {
+	for _, worker := range ps.KnownWorkers {
+		if strings.HasPrefix(worker.Id, "reserved_resource_worker") {
+			count++
+		}
+	}
+	return
+}
+
+// Return the server status
+func PulpApiGetStatus(n *Node, client *pulp.Client) (status *PulpStatus, err error) {
+	req, err := client.NewRequest("GET", "status/", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	status = new(PulpStatus)
+	_, err = client.Do(req, status)
+	if err != nil {
+		n.logger().With("error", err).Errorf("status query failed")
+		return nil, err
+	}
+
+	n.logger().With("version", status.Versions.PlatformVersion).Infof("status queried")
+
+	return status, err
+}
+
+// Cancel the task
+func PulpApiCancelTask(n *Node, client *pulp.Client, taskId string) (err error) {
+	req, err := client.NewRequest("DELETE", fmt.Sprintf("tasks/%v/", taskId), nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Do(req, nil)
+	if err != nil {
+		n.logger().With("task", taskId).With("error", err).Errorf("task cancel failed")
+		return err
+	}
+
+	n.logger().With("task", taskId).Infof("task canceled")
+	return
+}
+
+// The delay between the polls of a sync task
+var PollInterval = 500 * time.Millisecond
+
 func PulpApiSyncRepo(n *Node, client *pulp.Client, repositories []string, progressChannel chan SyncProgress) (err error) {
 
 	waitingTimeout := 10
@@ -108,41 +202,86 @@ func PulpApiSyncRepo(n *Node, client *pulp.Client, repositories []string, progre
 	var remoteRepos []*pulp.Repository
 	remoteRepos, err = PulpApiGetRepos(n, client)
 
+	// without the repository list, none of the repositories can be synced.
+	// the node fails once, rather than each repository as missing
+	if err != nil && !n.IsRoot() {
+		n.Errors = append(n.Errors, err)
+		for _, repository := range repositories {
+			if !n.RepositoryApplies(repository) {
+				continue
+			}
+			sp := SyncProgress{
+				Repository: repository,
+				Node:       n,
+				State:      "error",
+				Error:      err,
+				NodeError:  true,
+			}
+			n.sendProgress(progressChannel, sp)
+		}
+		return err
+	}
+
 	if !n.IsRoot() {
 
 	REPOSITORY_LOOP:
 		for _, repository := range repositories {
 
+			// check if repo is deliberately absent on target node
+			if !n.RepositoryApplies(repository) {
+				sp := SyncProgress{
+					Repository: repository,
+					Node:       n,
+					State:      "not applicable",
+				}
+				n.sendProgress(progressChannel, sp)
+				continue REPOSITORY_LOOP
+			}
+
 			repoExists := NodeContainsRepo(remoteRepos, repository)
 			_ = "breakpoint"
 
 			// check if repo exists on target node
 			if !repoExists {
 				errorMsg := fmt.Sprintf("repository '%v' does not exist on node %v", repository, n.Fqdn)
-				err = errors.New(errorMsg)
+				err = newKindError(MissingRepositoryError, errorMsg)
 				// n.Errors = append(n.Errors, err)
 				n.RepositoryError[repository] = err
 				sp := SyncProgress{
 					Repository: repository,
 					Node:       n,
 					State:      "error",
+					Error:      err,
 				}
-				progressChannel <- sp
+				n.sendProgress(progressChannel, sp)
 				continue REPOSITORY_LOOP
 			}
 
 			// check if repo feed points to a valid parent node repository
 			if !repoExists {
 				errorMsg := fmt.Sprintf("repository '%v' does not exist on node %v", repository, n.Fqdn)
-				err = errors.New(errorMsg)
+				err = newKindError(MissingRepositoryError, errorMsg)
 				// n.Errors = append(n.Errors, err)
 				n.RepositoryError[repository] = err
 				sp := SyncProgress{
 					Repository: repository,
 					Node:       n,
 					State:      "error",
+					Error:      err,
+				}
+				n.sendProgress(progressChannel, sp)
+				continue REPOSITORY_LOOP
+			}
+
+			// the failure policy stops starting syncs
+			if !n.policy.Allows(n) {
+				sp := SyncProgress{
+					Repository: repository,
+					Node:       n,
+					State:      "skipped",
+					Message:    fmt.Sprintf("sync not started, %v", n.policy.Reason()),
 				}
-				progressChannel <- sp
+				n.sendProgress(progressChannel, sp)
 				continue REPOSITORY_LOOP
 			}
 
@@ -154,15 +293,18 @@ func PulpApiSyncRepo(n *Node, client *pulp.Client, repositories []string, progre
 					Repository: repository,
 					Node:       n,
 					State:      "error",
+					Error:      err,
 				}
-				progressChannel <- sp
+				n.sendProgress(progressChannel, sp)
 				continue REPOSITORY_LOOP
 			}
 
 			syncTaskId := callReport.SpawnedTasks[0].TaskId
+			n.logger().With("repository", repository).With("task", syncTaskId).Infof("sync triggered")
 			state := "init"
 
 			progressTries := 0
+			lastSizeTotal, lastItemsTotal := 0, 0
 		PROGRESS_LOOP:
 			for (state != "finished") && (state != "error") {
 				progressTries++
@@ -172,23 +314,42 @@ func PulpApiSyncRepo(n *Node, client *pulp.Client, repositories []string, progre
 					sp := SyncProgress{
 						Repository: repository,
 						Node:       n,
+						TaskId:     syncTaskId,
 						State:      "skipped",
 						Message:    warningMsg,
 					}
-					progressChannel <- sp
+					n.sendProgress(progressChannel, sp)
 					// break the process loop
 					continue REPOSITORY_LOOP
 				}
 
+				if n.policy != nil && n.policy.CancelTasks && !n.policy.Allows(n) {
+					message := fmt.Sprintf("sync task canceled, %v", n.policy.Reason())
+					if err := PulpApiCancelTask(n, client, syncTaskId); err != nil {
+						message = fmt.Sprintf("sync task cancel failed (%v), %v", err, n.policy.Reason())
+					}
+					sp := SyncProgress{
+						Repository: repository,
+						Node:       n,
+						TaskId:     syncTaskId,
+						State:      "skipped",
+						Message:    message,
+					}
+					n.sendProgress(progressChannel, sp)
+					continue REPOSITORY_LOOP
+				}
+
 				task, _, err := client.Tasks.GetTask(syncTaskId)
 				if err != nil {
 					n.RepositoryError[repository] = err
 					sp := SyncProgress{
 						Repository: repository,
 						Node:       n,
+						TaskId:     syncTaskId,
 						State:      "error",
+						Error:      err,
 					}
-					progressChannel <- sp
+					n.sendProgress(progressChannel, sp)
 					continue REPOSITORY_LOOP
 				}
 
@@ -201,10 +362,12 @@ func PulpApiSyncRepo(n *Node, client *pulp.Client, repositories []string, progre
 					sp := SyncProgress{
 						Repository: repository,
 						Node:       n,
+						TaskId:     syncTaskId,
 						State:      "error",
+						Error:      err,
 					}
 
-					progressChannel <- sp
+					n.sendProgress(progressChannel, sp)
 					continue REPOSITORY_LOOP
 				}
 
@@ -217,17 +380,19 @@ func PulpApiSyncRepo(n *Node, client *pulp.Client, repositories []string, progre
 						// In case of infinite waiting, kill the task (TODO) and exit with error
 
 						errorMsg := fmt.Sprintf("sync task '%v' has reached timeout in waiting state", task.Id)
-						err = errors.New(errorMsg)
+						err = newKindError(WorkersError, errorMsg)
 						// n.Errors = append(n.Errors, err)
 						n.RepositoryError[repository] = err
 
 						sp := SyncProgress{
 							Repository: repository,
 							Node:       n,
+							TaskId:     syncTaskId,
 							State:      "error",
+							Error:      err,
 						}
 
-						progressChannel <- sp
+						n.sendProgress(progressChannel, sp)
 						return err
 					}
 				}
@@ -236,15 +401,29 @@ func PulpApiSyncRepo(n *Node, client *pulp.Client, repositories []string, progre
 				sp := SyncProgress{
 					Repository: repository,
 					Node:       n,
+					TaskId:     syncTaskId,
 					State:      state,
 				}
 
+				// the final sizes come from the task result, else from the last running report
+				if task.State == "finished" {
+					if content := task.Result.Details.Content; content != nil {
+						sp.SizeTotal = content.SizeTotal
+						sp.ItemsTotal = content.ItemsTotal
+					} else {
+						sp.SizeTotal = lastSizeTotal
+						sp.ItemsTotal = lastItemsTotal
+					}
+				}
+
 				if task.State == "running" {
 					if task.ProgressReport.YumImporter.Content != nil {
 						sp.SizeTotal = task.ProgressReport.YumImporter.Content.SizeTotal
 						sp.SizeLeft = task.ProgressReport.YumImporter.Content.SizeLeft
 						sp.ItemsTotal = task.ProgressReport.YumImporter.Content.ItemsTotal
 						sp.ItemsLeft = task.ProgressReport.YumImporter.Content.ItemsLeft
+						lastSizeTotal = sp.SizeTotal
+						lastItemsTotal = sp.ItemsTotal
 					} else {
 						if progressTries <= waitingRetries {
 							time.Sleep(time.Duration(waitingTimeout) * time.Second)
@@ -261,17 +440,19 @@ func PulpApiSyncRepo(n *Node, client *pulp.Client, repositories []string, progre
 							sp := SyncProgress{
 								Repository: repository,
 								Node:       n,
+								TaskId:     syncTaskId,
 								State:      "error",
+								Error:      err,
 							}
 
-							progressChannel <- sp
+							n.sendProgress(progressChannel, sp)
 							return err
 						}
 
 					}
 				}
-				progressChannel <- sp
-				time.Sleep(500 * time.Millisecond)
+				n.sendProgress(progressChannel, sp)
+				time.Sleep(PollInterval)
 			}
 		}
 	}
@@ -1,10 +1,22 @@
 package models
 
+import (
+	"path"
+	"strings"
+)
+
 type Repository struct {
 	Name string
 	Feed string
 }
 
+// Repositories deliberately carried (include) or not carried (exclude) by a node.
+// Both lists accept glob patterns. An empty include list carries all repositories.
+type RepositoryFilter struct {
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
 func (r *Repository) GetFeedHost() (host string) {
 	return
 }
@@ -12,3 +24,33 @@ func (r *Repository) GetFeedHost() (host string) {
 func (r *Repository) GetFeedRepository() (host string) {
 	return
 }
+
+// Is the given repository name a glob pattern?
+func IsRepositoryPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// Matches the given glob pattern?
+func (r *Repository) MatchPattern(pattern string) bool {
+	matched, err := path.Match(pattern, r.Name)
+	return err == nil && matched
+}
+
+// Does the filter allow the given repository?
+func (f *RepositoryFilter) Allows(repository string) bool {
+	r := Repository{Name: repository}
+	for _, pattern := range f.Exclude {
+		if r.MatchPattern(pattern) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if r.MatchPattern(pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,361 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A sync or check run on a stage
+type Run struct {
+	Id           string
+	Command      string
+	Trigger      string
+	User         string
+	Stage        string
+	Fqdns        []string
+	Tags         []string
+	Repositories []string
+	Start        time.Time
+	End          time.Time
+	State        string
+	Message      string
+	// the estimated remaining time of the stage while running
+	StageEtaSeconds float64 `json:",omitempty"`
+	Nodes           map[string]*NodeState
+	Results         []*RepositoryResult
+
+	mu sync.Mutex
+}
+
+// The error state of a node after a run
+type NodeState struct {
+	Errors          []string
+	RepositoryError map[string]string
+}
+
+// Has the node errors?
+func (ns *NodeState) HasError() bool {
+	return len(ns.Errors) > 0 || len(ns.RepositoryError) > 0
+}
+
+// Get the error states of the stage nodes
+func newNodeStates(s *Stage) (states map[string]*NodeState) {
+	states = make(map[string]*NodeState)
+	for _, n := range s.Nodes {
+		ns := &NodeState{
+			RepositoryError: make(map[string]string),
+		}
+		for _, e := range n.Errors {
+			ns.Errors = append(ns.Errors, e.Error())
+		}
+		for repository, e := range n.RepositoryError {
+			ns.RepositoryError[repository] = e.Error()
+		}
+		states[n.Fqdn] = ns
+	}
+	return
+}
+
+// The number of nodes and repository results of a run by state
+type RunCounts struct {
+	Nodes         int
+	FailedNodes   int
+	Results       int
+	Finished      int
+	Failed        int
+	Skipped       int
+	NotApplicable int
+	SizeTotal     int
+	ItemsTotal    int
+}
+
+// The outcome of a repository on a node
+type RepositoryResult struct {
+	Fqdn       string
+	Repository string
+	TaskId     string
+	State      string
+	Message    string
+	Error      string
+	Start      time.Time
+	End        time.Time
+	SizeTotal  int
+	ItemsTotal int
+	// in bytes per second, the average over the sync once finished
+	Throughput float64 `json:",omitempty"`
+	// the estimated remaining time while running
+	EtaSeconds float64 `json:",omitempty"`
+}
+
+func NewRun(command string, stage string) *Run {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return &Run{
+		Id:      fmt.Sprintf("%v-%v", time.Now().Format("20060102T150405"), hex.EncodeToString(id)),
+		Command: command,
+		Trigger: "cli",
+		User:    currentUser(),
+		Stage:   stage,
+		State:   "running",
+		Start:   time.Now(),
+	}
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// Encode the run, safe while the run is executing
+func (r *Run) MarshalJSON() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the plain struct has no MarshalJSON method
+	type plainRun Run
+	return json.Marshal((*plainRun)(r))
+}
+
+// Has the run ended?
+func (r *Run) IsDone() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.State != "running"
+}
+
+// Get the duration of the run, up to now if still running
+func (r *Run) Duration() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.End.IsZero() {
+		return time.Since(r.Start)
+	}
+	return r.End.Sub(r.Start)
+}
+
+// Get the names of the nodes with errors, sorted
+func (r *Run) FailedNodes() (fqdns []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for fqdn, ns := range r.Nodes {
+		if ns.HasError() {
+			fqdns = append(fqdns, fqdn)
+		}
+	}
+	sort.Strings(fqdns)
+	return
+}
+
+// Count the nodes and repository results by state
+func (r *Run) Counts() (counts RunCounts) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts.Nodes = len(r.Nodes)
+	for _, ns := range r.Nodes {
+		if ns.HasError() {
+			counts.FailedNodes++
+		}
+	}
+	counts.Results = len(r.Results)
+	for _, result := range r.Results {
+		switch result.State {
+		case "finished", "passed":
+			counts.Finished++
+			counts.SizeTotal += result.SizeTotal
+			counts.ItemsTotal += result.ItemsTotal
+		case "error":
+			counts.Failed++
+		case "skipped":
+			counts.Skipped++
+		case "not applicable":
+			counts.NotApplicable++
+		}
+	}
+	return
+}
+
+// Get the result of the repository on the node
+func (r *Run) GetResult(fqdn string, repository string) *RepositoryResult {
+	for _, result := range r.Results {
+		if result.Fqdn == fqdn && result.Repository == repository {
+			return result
+		}
+	}
+	return nil
+}
+
+// Record a sync progress event
+func (r *Run) Observe(sp SyncProgress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	result := r.GetResult(sp.Node.Fqdn, sp.Repository)
+	if result == nil {
+		result = &RepositoryResult{
+			Fqdn:       sp.Node.Fqdn,
+			Repository: sp.Repository,
+			Start:      now,
+		}
+		r.Results = append(r.Results, result)
+	}
+
+	result.State = sp.State
+	result.EtaSeconds = sp.Eta.Seconds()
+	r.StageEtaSeconds = sp.StageEta.Seconds()
+	if sp.Throughput > 0 {
+		result.Throughput = sp.Throughput
+	}
+	if sp.TaskId != "" {
+		result.TaskId = sp.TaskId
+	}
+	if sp.Message != "" {
+		result.Message = sp.Message
+	}
+	switch sp.State {
+	case "running":
+		result.SizeTotal = sp.SizeTotal
+		result.ItemsTotal = sp.ItemsTotal
+	case "error":
+		if sp.Error != nil {
+			result.Error = sp.Error.Error()
+		}
+		result.End = now
+	case "finished":
+		if sp.SizeTotal > 0 || sp.ItemsTotal > 0 {
+			result.SizeTotal = sp.SizeTotal
+			result.ItemsTotal = sp.ItemsTotal
+		}
+		result.End = now
+	case "skipped", "not applicable":
+		result.End = now
+	}
+}
+
+// End the run with the final state of the stage
+func (r *Run) Finish(s *Stage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.End = time.Now()
+	r.StageEtaSeconds = 0
+	r.Nodes = newNodeStates(s)
+	if r.Command == "check" {
+		r.recordCheckResults(s)
+	}
+	r.State = "finished"
+	if s.HasError() {
+		r.State = "failed"
+	}
+	if reason := s.Policy.Reason(); reason != "" && r.Message == "" {
+		r.Message = reason
+	}
+}
+
+// checks send no progress events, the results come from the node states
+func (r *Run) recordCheckResults(s *Stage) {
+	for _, n := range s.Nodes {
+		if n.IsRoot() || len(n.Errors) > 0 {
+			continue
+		}
+		for _, repository := range r.Repositories {
+			result := &RepositoryResult{
+				Fqdn:       n.Fqdn,
+				Repository: repository,
+				Start:      r.Start,
+				End:        r.End,
+			}
+			switch err, hasError := n.RepositoryError[repository]; {
+			case !n.RepositoryApplies(repository):
+				result.State = "not applicable"
+			case hasError:
+				result.State = "error"
+				result.Error = err.Error()
+			case n.HasRepository(repository):
+				result.State = "passed"
+			default:
+				// not reached, the check stops at the first error of a node
+				continue
+			}
+			r.Results = append(r.Results, result)
+		}
+	}
+}
+
+// End the run without executing it
+func (r *Run) Abort(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.End = time.Now()
+	r.State = "failed"
+	r.Message = err.Error()
+}
+
+// Execute the run on a copy of its stage in the tree.
+// The progress events of a sync are passed to the handlers.
+func (r *Run) Execute(st *StageTree, handlers ...func(SyncProgress)) (err error) {
+	baseStage := st.GetStageByName(r.Stage)
+	if baseStage == nil {
+		errorMsg := fmt.Sprintf("stage '%v' not found", r.Stage)
+		err = errors.New(errorMsg)
+		r.Abort(err)
+		return err
+	}
+
+	// runs must not share the node states
+	stage := baseStage.Copy()
+	if len(r.Fqdns) > 0 || len(r.Tags) > 0 {
+		stage = stage.Filter(r.Fqdns, r.Tags)
+	}
+
+	repositories, err := stage.ExpandRepositories(r.Repositories)
+	if err != nil {
+		r.Abort(err)
+		return err
+	}
+	r.mu.Lock()
+	r.Repositories = repositories
+	r.mu.Unlock()
+
+	switch r.Command {
+	case "sync":
+		// the preflight gates the sync like on the command line
+		if _, err = stage.RunPreflight(repositories); err != nil {
+			r.Finish(stage)
+			r.Abort(err)
+			return err
+		}
+		progressChannel := make(chan SyncProgress)
+		go stage.Sync(repositories, progressChannel)
+		for sp := range progressChannel {
+			r.Observe(sp)
+			for _, handler := range handlers {
+				handler(sp)
+			}
+		}
+	case "check":
+		stage.Check(repositories)
+	default:
+		errorMsg := fmt.Sprintf("unknown command '%v'", r.Command)
+		err = errors.New(errorMsg)
+		r.Abort(err)
+		return err
+	}
+
+	r.Finish(stage)
+	return
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Get the directory the nodetree state is kept in
+func StateDir() string {
+	if dir := viper.GetString("state_dir"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.Getenv("HOME"), ".nodetree")
+}
+
+// Get the directory the runs are kept in
+func RunDir() string {
+	return filepath.Join(StateDir(), "runs")
+}
+
+func runFile(id string) string {
+	return filepath.Join(RunDir(), id+".json")
+}
+
+// the number of runs kept by default, see the keep_runs setting
+const defaultKeptRuns = 1000
+
+// Save the run, and remove the oldest runs above the kept runs
+func (r *Run) Save() (err error) {
+	file := runFile(r.Id)
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(file, content, 0644)
+	if err != nil {
+		return err
+	}
+	return PruneRuns(KeptRuns())
+}
+
+// Get the number of runs to keep, from the keep_runs setting
+func KeptRuns() int {
+	if viper.IsSet("keep_runs") {
+		return viper.GetInt("keep_runs")
+	}
+	return defaultKeptRuns
+}
+
+// Remove the oldest runs, keeping the given number of runs. 0 keeps all runs.
+func PruneRuns(keep int) (err error) {
+	if keep <= 0 {
+		return
+	}
+	ids, err := runIds()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(ids)-keep; i++ {
+		err = os.Remove(runFile(ids[i]))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load the run with the given id
+func LoadRun(id string) (r *Run, err error) {
+	content, err := ioutil.ReadFile(runFile(id))
+	if err != nil {
+		return nil, err
+	}
+	r = &Run{}
+	err = json.Unmarshal(content, r)
+	return
+}
+
+// Load the run with the given id or unique id prefix
+func FindRun(id string) (r *Run, err error) {
+	ids, err := runIds()
+	if err != nil {
+		return nil, err
+	}
+	found := ""
+	for _, known := range ids {
+		if known == id {
+			return LoadRun(id)
+		}
+		if strings.HasPrefix(known, id) {
+			if found != "" {
+				errorMsg := fmt.Sprintf("run id '%v' is ambiguous", id)
+				return nil, errors.New(errorMsg)
+			}
+			found = known
+		}
+	}
+	if found == "" {
+		errorMsg := fmt.Sprintf("run '%v' not found", id)
+		return nil, errors.New(errorMsg)
+	}
+	return LoadRun(found)
+}
+
+// Load the last saved runs matching the filter (nil for all), newest first.
+// Stops at the limit, 0 for all runs.
+func LoadRuns(limit int, filter func(*Run) bool) (runs []*Run, err error) {
+	ids, err := runIds()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(ids) - 1; i >= 0 && (limit <= 0 || len(runs) < limit); i-- {
+		r, err := LoadRun(ids[i])
+		if os.IsNotExist(err) {
+			// pruned in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if filter == nil || filter(r) {
+			runs = append(runs, r)
+		}
+	}
+	return
+}
+
+// the last runs the node states are taken from
+const nodeStatesRuns = 100
+
+// Get the error state of each node of the stage after the last check or sync
+// run covering the node. Runs on some nodes only do not hide the other nodes.
+func LatestNodeStates(stageName string) (states map[string]*NodeState, err error) {
+	runs, err := LoadRuns(nodeStatesRuns, func(r *Run) bool {
+		return r.Stage == stageName && (r.Command == "check" || r.Command == "sync") && r.IsDone()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		errorMsg := fmt.Sprintf("no check or sync run found for stage '%v'", stageName)
+		return nil, errors.New(errorMsg)
+	}
+
+	// newest first, the first state of a node is kept
+	states = make(map[string]*NodeState)
+	for _, r := range runs {
+		for fqdn, ns := range r.Nodes {
+			if _, exists := states[fqdn]; !exists {
+				states[fqdn] = ns
+			}
+		}
+	}
+	return
+}
+
+// Get the ids of the saved runs, oldest first
+func runIds() (ids []string, err error) {
+	files, err := ioutil.ReadDir(RunDir())
+	if os.IsNotExist(err) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	// ids start with the run start time
+	sort.Strings(ids)
+	return
+}
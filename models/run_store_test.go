@@ -0,0 +1,141 @@
+package models
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// save the given number of runs, alternating the stages lab and prd
+func saveTestRuns(t *testing.T, count int) {
+	for i := 0; i < count; i++ {
+		r := &Run{
+			Id:    fmt.Sprintf("20161104T0200%02d-run", i),
+			Stage: []string{"lab", "prd"}[i%2],
+		}
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func withStateDir(t *testing.T) func() {
+	stateDir, err := ioutil.TempDir("", "nodetree-runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("state_dir", stateDir)
+	return func() {
+		viper.Set("state_dir", "")
+		viper.Set("keep_runs", nil)
+		os.RemoveAll(stateDir)
+	}
+}
+
+func TestLoadRunsNewestFirst(t *testing.T) {
+	defer withStateDir(t)()
+	saveTestRuns(t, 6)
+
+	tests := []struct {
+		limit  int
+		stage  string
+		suffix []string
+	}{
+		{0, "", []string{"05", "04", "03", "02", "01", "00"}},
+		{2, "", []string{"05", "04"}},
+		{2, "lab", []string{"04", "02"}},
+		{0, "prd", []string{"05", "03", "01"}},
+	}
+	for _, test := range tests {
+		runs, err := LoadRuns(test.limit, func(r *Run) bool {
+			return test.stage == "" || r.Stage == test.stage
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, r := range runs {
+			ids = append(ids, r.Id)
+		}
+		want := []string{}
+		for _, suffix := range test.suffix {
+			want = append(want, "20161104T0200"+suffix+"-run")
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(want) {
+			t.Errorf("limit %v, stage '%v': got %v, want %v", test.limit, test.stage, ids, want)
+		}
+	}
+}
+
+func TestFindRunByPrefix(t *testing.T) {
+	defer withStateDir(t)()
+	saveTestRuns(t, 3)
+
+	if r, err := FindRun("20161104T020001"); err != nil || r.Id != "20161104T020001-run" {
+		t.Errorf("expected run 20161104T020001-run, got %v", err)
+	}
+	if _, err := FindRun("20161104T0200"); err == nil {
+		t.Errorf("expected an ambiguous prefix error")
+	}
+	if _, err := FindRun("2017"); err == nil {
+		t.Errorf("expected a not found error")
+	}
+}
+
+func TestSaveRunPrunesOldRuns(t *testing.T) {
+	defer withStateDir(t)()
+	viper.Set("keep_runs", 3)
+	saveTestRuns(t, 5)
+
+	ids, err := runIds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[20161104T020002-run 20161104T020003-run 20161104T020004-run]"
+	if fmt.Sprint(ids) != want {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+}
+
+func TestLatestNodeStates(t *testing.T) {
+	defer withStateDir(t)()
+	failed := &NodeState{Errors: []string{"no live workers"}}
+	passed := &NodeState{}
+	runs := []*Run{
+		// a check of the whole stage
+		{Id: "20161104T020000-run", Command: "check", Stage: "lab", State: "failed",
+			Nodes: map[string]*NodeState{"root": passed, "dc1": failed, "dc2": failed}},
+		// a sync on dc2 only
+		{Id: "20161104T020001-run", Command: "sync", Stage: "lab", State: "finished", Fqdns: []string{"dc2"},
+			Nodes: map[string]*NodeState{"dc2": passed}},
+		// other stages and running runs are ignored
+		{Id: "20161104T020002-run", Command: "sync", Stage: "prd", State: "finished",
+			Nodes: map[string]*NodeState{"dc1": passed}},
+		{Id: "20161104T020003-run", Command: "sync", Stage: "lab", State: "running"},
+	}
+	for _, r := range runs {
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	states, err := LatestNodeStates("lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"root": false, "dc1": true, "dc2": false}
+	if len(states) != len(want) {
+		t.Errorf("expected the states of %v nodes, got %v", len(want), len(states))
+	}
+	for fqdn, hasError := range want {
+		if ns, exists := states[fqdn]; !exists || ns.HasError() != hasError {
+			t.Errorf("node %v: expected errors %v, got %v", fqdn, hasError, ns)
+		}
+	}
+
+	if _, err := LatestNodeStates("dev"); err == nil {
+		t.Errorf("expected an error without runs")
+	}
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"time"
+)
+
+// The totals of the repository results of a node or of a run
+type SummaryTotals struct {
+	Repositories    int
+	Finished        int
+	Failed          int
+	Skipped         int
+	NotApplicable   int
+	SizeTotal       int
+	ItemsTotal      int
+	DurationSeconds float64
+}
+
+// The outcome of a repository on a node, in the summary
+type SummaryRow struct {
+	Repository      string
+	State           string
+	DurationSeconds float64
+	SizeTotal       int
+	ItemsTotal      int
+}
+
+// The repository outcomes of a node with their totals
+type NodeSummary struct {
+	Fqdn         string
+	Repositories []*SummaryRow
+	Totals       SummaryTotals
+}
+
+// The end-of-run summary: the outcome of each repository on each node,
+// with totals per node and for the stage
+type RunSummary struct {
+	Id      string
+	Command string
+	Stage   string
+	State   string
+	Message string `json:",omitempty"`
+	Nodes   []*NodeSummary
+	Totals  SummaryTotals
+}
+
+func (t *SummaryTotals) add(row *SummaryRow) {
+	t.Repositories++
+	switch row.State {
+	case "finished", "passed":
+		t.Finished++
+		t.SizeTotal += row.SizeTotal
+		t.ItemsTotal += row.ItemsTotal
+	case "error":
+		t.Failed++
+	case "skipped":
+		t.Skipped++
+	case "not applicable":
+		t.NotApplicable++
+	}
+}
+
+func resultSeconds(start time.Time, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start).Seconds()
+}
+
+// Summarize the results of the run, with the nodes in the order of the stage tree
+func (r *Run) Summary(s *Stage) *RunSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	summary := &RunSummary{
+		Id:      r.Id,
+		Command: r.Command,
+		Stage:   r.Stage,
+		State:   r.State,
+		Message: r.Message,
+	}
+	summary.Totals.DurationSeconds = resultSeconds(r.Start, r.End)
+
+	fqdns := []string{}
+	if s != nil && s.PulpRootNode != nil {
+		s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+			fqdns = append(fqdns, n.Fqdn)
+		})
+	}
+	// nodes missing in the stage, e.g. summaries of loaded runs, come last
+	for _, result := range r.Results {
+		fqdns = appendUnique(fqdns, result.Fqdn)
+	}
+
+	for _, fqdn := range fqdns {
+		ns := &NodeSummary{Fqdn: fqdn}
+		var start, end time.Time
+		for _, result := range r.Results {
+			if result.Fqdn != fqdn {
+				continue
+			}
+			row := &SummaryRow{
+				Repository:      result.Repository,
+				State:           result.State,
+				DurationSeconds: resultSeconds(result.Start, result.End),
+				SizeTotal:       result.SizeTotal,
+				ItemsTotal:      result.ItemsTotal,
+			}
+			ns.Repositories = append(ns.Repositories, row)
+			ns.Totals.add(row)
+			summary.Totals.add(row)
+
+			if !result.Start.IsZero() && (start.IsZero() || result.Start.Before(start)) {
+				start = result.Start
+			}
+			if result.End.After(end) {
+				end = result.End
+			}
+		}
+		if len(ns.Repositories) == 0 {
+			continue
+		}
+		ns.Totals.DurationSeconds = resultSeconds(start, end)
+		summary.Nodes = append(summary.Nodes, ns)
+	}
+	return summary
+}
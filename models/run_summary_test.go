@@ -0,0 +1,57 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestRunSummary(t *testing.T) {
+	r := newTestRun()
+	// a node that is not in the stage, e.g. removed since the run
+	r.Results = append(r.Results, &RepositoryResult{
+		Fqdn: "old1", Repository: "rhel7-os", State: "finished",
+		Start: testRunAt(10), End: testRunAt(20), SizeTotal: 500, ItemsTotal: 5,
+	})
+
+	summary := r.Summary(newTestStage())
+
+	// in the order of the tree, the root node has no results
+	tests := []struct {
+		fqdn   string
+		totals SummaryTotals
+	}{
+		{"dc1", SummaryTotals{Repositories: 2, Finished: 1, Failed: 1, SizeTotal: 1000, ItemsTotal: 10, DurationSeconds: 5}},
+		{"dmz1", SummaryTotals{Repositories: 2, Skipped: 2}},
+		{"dc2", SummaryTotals{Repositories: 2, Finished: 1, NotApplicable: 1, SizeTotal: 3000, ItemsTotal: 30, DurationSeconds: 8}},
+		{"old1", SummaryTotals{Repositories: 1, Finished: 1, SizeTotal: 500, ItemsTotal: 5, DurationSeconds: 10}},
+	}
+	if len(summary.Nodes) != len(tests) {
+		t.Fatalf("got %v nodes, want %v", len(summary.Nodes), len(tests))
+	}
+	for i, test := range tests {
+		ns := summary.Nodes[i]
+		if ns.Fqdn != test.fqdn {
+			t.Errorf("node %v: got %v, want %v", i, ns.Fqdn, test.fqdn)
+			continue
+		}
+		if ns.Totals != test.totals {
+			t.Errorf("%v: got totals %+v, want %+v", test.fqdn, ns.Totals, test.totals)
+		}
+	}
+
+	want := SummaryTotals{
+		Repositories:    7,
+		Finished:        3,
+		Failed:          1,
+		Skipped:         2,
+		NotApplicable:   1,
+		SizeTotal:       4500,
+		ItemsTotal:      45,
+		DurationSeconds: 20,
+	}
+	if summary.Totals != want {
+		t.Errorf("got totals %+v, want %+v", summary.Totals, want)
+	}
+	if summary.Id != r.Id || summary.State != "failed" {
+		t.Errorf("got run %v %v, want %v failed", summary.Id, summary.State, r.Id)
+	}
+}
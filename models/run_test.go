@@ -0,0 +1,62 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+var testRunStart = time.Date(2016, 11, 4, 2, 0, 0, 0, time.UTC)
+
+func testRunAt(second int) time.Time {
+	return testRunStart.Add(time.Duration(second) * time.Second)
+}
+
+// a finished sync over the nodes of the test stage, with an error message to escape
+func newTestRun() *Run {
+	script := "<script>alert('x')</script>"
+	return &Run{
+		Id:           "20161104T020000-0a1b2c3d",
+		Command:      "sync",
+		Trigger:      "cli",
+		Stage:        "test",
+		Repositories: []string{"rhel7-os", "rhel7-updates"},
+		Start:        testRunAt(0),
+		End:          testRunAt(20),
+		State:        "failed",
+		Nodes: map[string]*NodeState{
+			"root": {},
+			"dc1":  {RepositoryError: map[string]string{"rhel7-updates": script}},
+			"dmz1": {RepositoryError: map[string]string{"rhel7-extras": "repository not found"}},
+			"dc2":  {Errors: []string{"no live workers on <b>dc2</b>"}},
+		},
+		Results: []*RepositoryResult{
+			{Fqdn: "dc1", Repository: "rhel7-os", State: "finished", Start: testRunAt(0), End: testRunAt(5), SizeTotal: 1000, ItemsTotal: 10},
+			{Fqdn: "dc1", Repository: "rhel7-updates", State: "error", Error: script, Start: testRunAt(0), End: testRunAt(2)},
+			{Fqdn: "dmz1", Repository: "rhel7-os", State: "skipped", Message: "failed on an ancestor"},
+			{Fqdn: "dmz1", Repository: "rhel7-updates", State: "skipped"},
+			{Fqdn: "dc2", Repository: "rhel7-os", State: "finished", Start: testRunAt(1), End: testRunAt(9), SizeTotal: 3000, ItemsTotal: 30},
+			{Fqdn: "dc2", Repository: "rhel7-updates", State: "not applicable"},
+		},
+	}
+}
+
+// run with -race: the duration is read while the run finishes
+func TestRunDurationWhileFinishing(t *testing.T) {
+	r := NewRun("sync", "test")
+	s := newTestStage()
+
+	done := make(chan bool)
+	go func() {
+		r.Finish(s)
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		if r.Duration() < 0 {
+			t.Fatalf("negative duration")
+		}
+	}
+	<-done
+	if r.Duration() != r.End.Sub(r.Start) {
+		t.Errorf("expected the duration up to the end of the run")
+	}
+}
@@ -0,0 +1,40 @@
+package models
+
+// A run of a stage on a cron schedule
+type Schedule struct {
+	Name         string   `yaml:"name"`
+	Cron         string   `yaml:"cron"`
+	Stage        string   `yaml:"stage"`
+	Command      string   `yaml:"command,omitempty"`
+	Repositories []string `yaml:"repositories,omitempty"`
+	Fqdns        []string `yaml:"fqdns,omitempty"`
+	Tags         []string `yaml:"tags,omitempty"`
+}
+
+// Get the command of the schedule, sync by default
+func (sc *Schedule) GetCommand() string {
+	if sc.Command == "" {
+		return "sync"
+	}
+	return sc.Command
+}
+
+// Build a new run of the schedule
+func (sc *Schedule) NewRun() *Run {
+	run := NewRun(sc.GetCommand(), sc.Stage)
+	run.Trigger = "schedule:" + sc.Name
+	run.Repositories = sc.Repositories
+	run.Fqdns = sc.Fqdns
+	run.Tags = sc.Tags
+	return run
+}
+
+// Get the schedule with the given name
+func (st *StageTree) GetSchedule(name string) *Schedule {
+	for _, schedule := range st.Schedules {
+		if schedule.Name == name {
+			return schedule
+		}
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSimulationBandwidth = 10 * 1024 * 1024
+	defaultRepositorySize      = 1024 * 1024 * 1024
+	simulatedItemSize          = 1024 * 1024
+)
+
+// The bandwidths, repository sizes and failure probabilities of a simulated sync.
+// Bandwidths are in bytes per second, sizes in bytes.
+type SimulationSettings struct {
+	Bandwidth              int                `mapstructure:"bandwidth" yaml:"bandwidth,omitempty"`
+	NodeBandwidth          map[string]int     `mapstructure:"node_bandwidth" yaml:"node_bandwidth,omitempty"`
+	RepositorySize         int                `mapstructure:"repository_size" yaml:"repository_size,omitempty"`
+	RepositorySizes        map[string]int     `mapstructure:"repository_sizes" yaml:"repository_sizes,omitempty"`
+	FailureProbability     float64            `mapstructure:"failure_probability" yaml:"failure_probability,omitempty"`
+	NodeFailureProbability map[string]float64 `mapstructure:"node_failure_probability" yaml:"node_failure_probability,omitempty"`
+}
+
+// Are any settings defined?
+func (ss SimulationSettings) isSet() bool {
+	return ss.Bandwidth != 0 ||
+		len(ss.NodeBandwidth) > 0 ||
+		ss.RepositorySize != 0 ||
+		len(ss.RepositorySizes) > 0 ||
+		ss.FailureProbability != 0 ||
+		len(ss.NodeFailureProbability) > 0
+}
+
+// A simulated pulp backend answering the api calls of all nodes.
+// The virtual time runs Speed times faster than the real time.
+type Simulation struct {
+	Settings     SimulationSettings
+	Speed        float64
+	Repositories []string
+	// nodes failing all their syncs
+	FailingNodes []string
+
+	mu    sync.Mutex
+	rand  *rand.Rand
+	tasks map[string]*simulatedTask
+}
+
+type simulatedTask struct {
+	id         string
+	fqdn       string
+	repository string
+	start      time.Time
+	size       int
+	duration   time.Duration
+	// the virtual time the task fails at, if failing
+	failAt   time.Duration
+	fails    bool
+	canceled bool
+}
+
+// The simulation used by the pulp api clients, if any
+var ActiveSimulation *Simulation
+
+func NewSimulation(settings SimulationSettings, speed float64, seed int64) *Simulation {
+	return &Simulation{
+		Settings: settings,
+		Speed:    speed,
+		rand:     rand.New(rand.NewSource(seed)),
+		tasks:    make(map[string]*simulatedTask),
+	}
+}
+
+// Get the bandwidth of the node in bytes per second
+func (sim *Simulation) Bandwidth(fqdn string) int {
+	if bandwidth, exists := sim.Settings.NodeBandwidth[fqdn]; exists && bandwidth > 0 {
+		return bandwidth
+	}
+	if sim.Settings.Bandwidth > 0 {
+		return sim.Settings.Bandwidth
+	}
+	return defaultSimulationBandwidth
+}
+
+// Get the size of the repository in bytes
+func (sim *Simulation) RepositorySize(repository string) int {
+	if size, exists := sim.Settings.RepositorySizes[repository]; exists && size > 0 {
+		return size
+	}
+	if sim.Settings.RepositorySize > 0 {
+		return sim.Settings.RepositorySize
+	}
+	return defaultRepositorySize
+}
+
+func (sim *Simulation) failureProbability(fqdn string) float64 {
+	for _, failing := range sim.FailingNodes {
+		if failing == fqdn {
+			return 1
+		}
+	}
+	if probability, exists := sim.Settings.NodeFailureProbability[fqdn]; exists {
+		return probability
+	}
+	return sim.Settings.FailureProbability
+}
+
+// Take the sizes of the repositories without a configured size from the
+// last finished syncs in the history runs, newest first
+func (sim *Simulation) SizesFromHistory(runs []*Run) {
+	if sim.Settings.RepositorySizes == nil {
+		sim.Settings.RepositorySizes = make(map[string]int)
+	}
+	// the runs are newest first
+	for _, run := range runs {
+		for _, result := range run.Results {
+			if result.State != "finished" || result.SizeTotal == 0 {
+				continue
+			}
+			if _, exists := sim.Settings.RepositorySizes[result.Repository]; !exists {
+				sim.Settings.RepositorySizes[result.Repository] = result.SizeTotal
+			}
+		}
+	}
+}
+
+// Add the repositories with a known size to the repositories of the nodes
+func (sim *Simulation) AddKnownRepositories() {
+	for repository := range sim.Settings.RepositorySizes {
+		sim.Repositories = appendUnique(sim.Repositories, repository)
+	}
+	sort.Strings(sim.Repositories)
+}
+
+// Add the repositories to the repositories of the nodes
+func (sim *Simulation) AddRepositories(repositories []string) {
+	for _, repository := range repositories {
+		sim.Repositories = appendUnique(sim.Repositories, repository)
+	}
+}
+
+func (sim *Simulation) startTask(fqdn string, repository string) *simulatedTask {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	size := sim.RepositorySize(repository)
+	seconds := float64(size) / float64(sim.Bandwidth(fqdn))
+	task := &simulatedTask{
+		id:         fmt.Sprintf("sim-%v-%v-%v", fqdn, repository, len(sim.tasks)),
+		fqdn:       fqdn,
+		repository: repository,
+		start:      time.Now(),
+		size:       size,
+		duration:   time.Duration(seconds * float64(time.Second)),
+	}
+	if sim.rand.Float64() < sim.failureProbability(fqdn) {
+		task.fails = true
+		task.failAt = time.Duration(sim.rand.Float64() * float64(task.duration))
+	}
+	sim.tasks[task.id] = task
+	return task
+}
+
+func (sim *Simulation) cancelTask(id string) bool {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	task, exists := sim.tasks[id]
+	if exists {
+		task.canceled = true
+	}
+	return exists
+}
+
+// Get the pulp task report of the task at the current virtual time
+func (sim *Simulation) taskReport(id string) (report map[string]interface{}, exists bool) {
+	sim.mu.Lock()
+	task, exists := sim.tasks[id]
+	sim.mu.Unlock()
+	if !exists {
+		return nil, false
+	}
+
+	if task.canceled {
+		return map[string]interface{}{"task_id": id, "state": "canceled"}, true
+	}
+
+	elapsed := time.Duration(float64(time.Since(task.start)) * sim.Speed)
+	if task.fails && elapsed >= task.failAt {
+		return map[string]interface{}{
+			"task_id": id,
+			"state":   "error",
+			"progress_report": map[string]interface{}{
+				"yum_importer": map[string]interface{}{
+					"metadata": map[string]interface{}{"error": "simulated failure"},
+				},
+			},
+		}, true
+	}
+
+	state := "running"
+	sizeLeft := task.size
+	if task.duration > 0 {
+		sizeLeft = int(float64(task.size) * (1 - float64(elapsed)/float64(task.duration)))
+	}
+	if elapsed >= task.duration || sizeLeft <= 0 {
+		state = "finished"
+		sizeLeft = 0
+	}
+	itemsTotal := task.size/simulatedItemSize + 1
+	content := map[string]interface{}{
+		"size_total":  task.size,
+		"size_left":   sizeLeft,
+		"items_total": itemsTotal,
+		"items_left":  itemsTotal * sizeLeft / task.size,
+	}
+	return map[string]interface{}{
+		"task_id": id,
+		"state":   state,
+		"progress_report": map[string]interface{}{
+			"yum_importer": map[string]interface{}{"content": content},
+		},
+		"result": map[string]interface{}{
+			"details": map[string]interface{}{"content": content},
+		},
+	}, true
+}
+
+// An http transport answering the pulp api calls of a node from the simulation
+type simulationTransport struct {
+	simulation *Simulation
+	node       *Node
+}
+
+func (t *simulationTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	path := strings.TrimPrefix(exchangeUrl(req), "/pulp/api/v2/")
+	if i := strings.Index(path, "?"); i != -1 {
+		path = path[:i]
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case req.Method == "GET" && path == "repositories/":
+		parentFqdn := t.node.parentFqdn()
+		repositories := []interface{}{}
+		for _, repository := range t.simulation.Repositories {
+			repositories = append(repositories, map[string]interface{}{
+				"id": repository,
+				"importers": []interface{}{map[string]interface{}{
+					"config": map[string]interface{}{
+						"feed": fmt.Sprintf("http://%v/pulp/repos/%v/", parentFqdn, repository),
+					},
+				}},
+			})
+		}
+		return simulatedResponse(req, http.StatusOK, repositories)
+
+	case req.Method == "POST" && len(parts) == 4 && parts[0] == "repositories" && parts[2] == "actions" && parts[3] == "sync":
+		task := t.simulation.startTask(t.node.Fqdn, parts[1])
+		return simulatedResponse(req, http.StatusAccepted, map[string]interface{}{
+			"spawned_tasks": []interface{}{map[string]interface{}{"task_id": task.id}},
+		})
+
+	case req.Method == "GET" && len(parts) == 2 && parts[0] == "tasks":
+		if report, exists := t.simulation.taskReport(parts[1]); exists {
+			return simulatedResponse(req, http.StatusOK, report)
+		}
+
+	case req.Method == "DELETE" && len(parts) == 2 && parts[0] == "tasks":
+		if t.simulation.cancelTask(parts[1]) {
+			return simulatedResponse(req, http.StatusAccepted, nil)
+		}
+
+	case req.Method == "GET" && path == "status/":
+		return simulatedResponse(req, http.StatusOK, map[string]interface{}{
+			"api_version":          "2",
+			"versions":             map[string]interface{}{"platform_version": "simulated"},
+			"database_connection":  map[string]interface{}{"connected": true},
+			"messaging_connection": map[string]interface{}{"connected": true},
+			"known_workers": []interface{}{
+				map[string]interface{}{"_id": "reserved_resource_worker-0@simulation"},
+			},
+		})
+	}
+
+	return simulatedResponse(req, http.StatusNotFound, map[string]interface{}{
+		"error_message": fmt.Sprintf("%v %v is not simulated", req.Method, path),
+		"http_status":   http.StatusNotFound,
+	})
+}
+
+func simulatedResponse(req *http.Request, status int, value interface{}) (*http.Response, error) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%v %v", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(content)),
+		ContentLength: int64(len(content)),
+		Request:       req,
+	}, nil
+}
+
+// The simulated timeline of a node, in virtual time from the start of the sync
+type SimulatedNode struct {
+	Fqdn  string
+	Depth int
+	Start time.Duration
+	End   time.Duration
+	// the repositories failing on the node
+	Failed []string
+	// the repositories skipped after failures or by the failure policy
+	Skipped []string
+	// the descendants waiting on the node
+	Descendants []string
+}
+
+// The estimated duration, critical path and failure impact of a simulated sync
+type SimulationReport struct {
+	Total        time.Duration
+	Nodes        []*SimulatedNode
+	CriticalPath []*SimulatedNode
+}
+
+// Compute the report from the results of the run, in virtual time from its start.
+// Nodes without syncs, e.g. stopped by the failure policy, take no time after their parent.
+func (sim *Simulation) Report(run *Run, s *Stage) (report *SimulationReport) {
+	virtual := func(t time.Time) time.Duration {
+		if t.Before(run.Start) {
+			return 0
+		}
+		return time.Duration(float64(t.Sub(run.Start)) * sim.Speed)
+	}
+
+	run.mu.Lock()
+	results := append([]*RepositoryResult{}, run.Results...)
+	run.mu.Unlock()
+
+	report = &SimulationReport{}
+	simulated := make(map[string]*SimulatedNode)
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		sn := &SimulatedNode{Fqdn: n.Fqdn, Depth: n.Depth}
+		if !n.IsRoot() {
+			sn.Start = simulated[n.Parent.Fqdn].End
+			sn.End = sn.Start
+		}
+
+		synced := false
+		for _, result := range results {
+			if result.Fqdn != n.Fqdn {
+				continue
+			}
+			switch result.State {
+			case "skipped":
+				sn.Skipped = append(sn.Skipped, result.Repository)
+				continue
+			case "not applicable":
+				continue
+			case "error":
+				sn.Failed = append(sn.Failed, result.Repository)
+			}
+			start, end := virtual(result.Start), virtual(result.End)
+			if !synced || start < sn.Start {
+				sn.Start = start
+			}
+			if !synced || end > sn.End {
+				sn.End = end
+			}
+			synced = true
+		}
+		sort.Strings(sn.Failed)
+		sort.Strings(sn.Skipped)
+
+		n.ChildTreeWalker(func(descendant *Node) {
+			sn.Descendants = append(sn.Descendants, descendant.Fqdn)
+		})
+		simulated[n.Fqdn] = sn
+		report.Nodes = append(report.Nodes, sn)
+
+		if sn.End > report.Total || report.CriticalPath == nil {
+			report.Total = sn.End
+			report.CriticalPath = nil
+			for node := n; node != nil; node = node.Parent {
+				report.CriticalPath = append([]*SimulatedNode{simulated[node.Fqdn]}, report.CriticalPath...)
+			}
+		}
+	})
+	return
+}
@@ -2,16 +2,27 @@ package models
 
 import (
 	// "fmt"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
 
 type Stage struct {
-	Name         string
-	PulpRootNode *Node
-	Leafs        []*Node
-	Nodes        []*Node
+	Name          string            `yaml:"name"`
+	InheritTags   bool              `mapstructure:"inherit_tags" yaml:"inherit_tags,omitempty"`
+	Template      string            `yaml:"-"`
+	Preflight     bool              `yaml:"preflight,omitempty"`
+	PreflightMode string            `mapstructure:"preflight_mode" yaml:"preflight_mode,omitempty"`
+	Vars          map[string]string `yaml:"-"`
+	PulpRootNode  *Node             `yaml:"pulprootnode"`
+	Leafs         []*Node           `yaml:"-"`
+	Nodes         []*Node           `yaml:"-"`
+	Tree          *StageTree        `mapstructure:"-" yaml:"-"`
+	File          string            `mapstructure:"-" yaml:"-"`
+	Line          int               `mapstructure:"-" yaml:"-"`
+	Policy        *FailurePolicy    `mapstructure:"-" yaml:"-"`
 }
 
 // Matches the given fqdn?
@@ -32,11 +43,24 @@ func (s *Stage) NodeTreeWalker(node *Node, f func(*Node)) {
 
 func (s *Stage) Init() {
 	pos := 1
+	s.Nodes = nil
+	s.Leafs = nil
 	s.NodeTreeWalker(s.PulpRootNode, func(node *Node) {
 		s.Nodes = append(s.Nodes, node)
 
-		// make the errors container
+		// reset the state of the last pass
+		node.Errors = nil
 		node.RepositoryError = make(map[string]error)
+		node.Repositories = nil
+		node.PreflightFailed = false
+
+		// resolve the settings carried by the tags
+		node.TagSettings = nil
+		for _, tag := range node.Tags {
+			if tagSetting := s.Tree.GetTagSetting(tag); tagSetting != nil {
+				node.TagSettings = append(node.TagSettings, tagSetting)
+			}
+		}
 
 		// set treePosition
 		node.TreePosition = pos
@@ -51,10 +75,19 @@ func (s *Stage) Init() {
 			n.Depth = node.Depth + 1
 			// set the parent node
 			n.Parent = node
+			// inherit the tags of the parent node
+			if s.MustInheritTags() {
+				n.InheritTags(node.Tags)
+			}
 		}
 	})
 }
 
+// Do the tags inherit down the tree?
+func (s *Stage) MustInheritTags() bool {
+	return s.InheritTags || (s.Tree != nil && s.Tree.InheritTags)
+}
+
 func (s *Stage) GetNodeByFqdn(nodeFqdn string) (node *Node) {
 	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
 		if n.Fqdn == nodeFqdn {
@@ -64,6 +97,9 @@ func (s *Stage) GetNodeByFqdn(nodeFqdn string) (node *Node) {
 	return node
 }
 
+// The delay before each node of a synced walk starts
+var WalkDelay = 50 * time.Millisecond
+
 func (s *Stage) SyncedNodeTreeWalker(f func(n *Node) error) {
 	s.Init()
 	// initialize the tree (waitgroups, prents, depth, etc)
@@ -81,11 +117,15 @@ func (s *Stage) SyncedNodeTreeWalker(f func(n *Node) error) {
 	// Walk the tree with syncronization
 	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
 		go func() {
-			time.Sleep(time.Millisecond * 50)
+			time.Sleep(WalkDelay)
 			// Wait
 			inWg[n.Fqdn].Wait()
-			// execute the function
-			f(n)
+			// execute the function, unless the failure policy stopped the syncs
+			if s.Policy.Allows(n) {
+				f(n)
+			} else {
+				s.Policy.skipNode(n)
+			}
 			// Set done on waitgroup
 			if n.IsLeaf() {
 				leafsWaitGroup.Done()
@@ -120,13 +160,46 @@ func (s *Stage) SyncAll(progressChannel chan SyncProgress) {
 func (s *Stage) Sync(repositories []string, progressChannel chan SyncProgress) {
 	defer close(progressChannel)
 
+	// the progress of the nodes gets the throughputs and remaining times
+	nodeChannel := make(chan SyncProgress)
+	tracked := make(chan bool)
+	tracker := NewProgressTracker(s, repositories)
+	go func() {
+		for sp := range nodeChannel {
+			tracker.Update(&sp)
+			progressChannel <- sp
+		}
+		close(tracked)
+	}()
+
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		n.policy = s.Policy
+	})
+
 	// Use the synced walk
 	s.SyncedNodeTreeWalker(func(n *Node) (serr error) {
 		// Execute the sync
-		n.Sync(repositories, progressChannel)
+		n.Sync(repositories, nodeChannel)
 		return
 	})
 
+	// the nodes kept from starting by the failure policy
+	for _, n := range s.Policy.NotStarted() {
+		if n.IsRoot() {
+			continue
+		}
+		for _, repository := range repositories {
+			n.sendProgress(nodeChannel, SyncProgress{
+				Repository: repository,
+				Node:       n,
+				State:      "skipped",
+				Message:    fmt.Sprintf("sync not started, %v", s.Policy.Reason()),
+			})
+		}
+	}
+
+	close(nodeChannel)
+	<-tracked
 	return
 }
 
@@ -137,6 +210,45 @@ func (s *Stage) Show() {
 	})
 }
 
+// Execute the function on all nodes concurrently, without dependencies
+func (s *Stage) ConcurrentNodeWalker(f func(n *Node)) {
+	s.Init()
+	var wg sync.WaitGroup
+	wg.Add(len(s.Nodes))
+	for _, n := range s.Nodes {
+		go func(n *Node) {
+			defer wg.Done()
+			f(n)
+		}(n)
+	}
+	wg.Wait()
+}
+
+// Query the repositories of all nodes concurrently and check their feeds.
+// Unreachable nodes are recorded, not aborting the update of the others.
+func (s *Stage) UpdateLive() {
+	s.ConcurrentNodeWalker(func(n *Node) {
+		start := time.Now()
+		err := n.UpdateRepositories()
+		n.ApiLatency = time.Since(start)
+		n.Reachable = err == nil
+	})
+
+	// the feeds can only be checked against a reachable parent
+	for _, n := range s.Nodes {
+		if n.Reachable && !n.IsRoot() && n.Parent.Reachable {
+			n.CheckRepositoryFeeds()
+		}
+	}
+}
+
+// Query the server status of all nodes concurrently
+func (s *Stage) Health() {
+	s.ConcurrentNodeWalker(func(n *Node) {
+		n.UpdateHealth()
+	})
+}
+
 func (s *Stage) CheckAll() {
 	// get all repositories exising on the root pulp node
 	s.PulpRootNode.UpdateRepositories()
@@ -159,8 +271,88 @@ func (s *Stage) Check(repositories []string) {
 	})
 }
 
+// Expand the repository sets (@name) and glob patterns to repository names.
+// Patterns are matched against the repositories of the root node.
+func (s *Stage) ExpandRepositories(names []string) (repositories []string, err error) {
+	var rootRepositories []Repository
+	rootFetched := false
+
+	for _, name := range names {
+		patterns := []string{name}
+		if strings.HasPrefix(name, "@") {
+			patterns, err = s.Tree.GetRepositorySet(strings.TrimPrefix(name, "@"))
+			if err != nil {
+				return repositories, err
+			}
+		}
+
+		for _, pattern := range patterns {
+			if !IsRepositoryPattern(pattern) {
+				repositories = appendUnique(repositories, pattern)
+				continue
+			}
+
+			if !rootFetched {
+				rootRepositories, err = s.PulpRootNode.GetRemoteRepositories()
+				if err != nil {
+					return repositories, err
+				}
+				rootFetched = true
+			}
+
+			matched := false
+			for _, rootRepository := range rootRepositories {
+				if rootRepository.MatchPattern(pattern) {
+					repositories = appendUnique(repositories, rootRepository.Name)
+					matched = true
+				}
+			}
+			if !matched {
+				errorMsg := fmt.Sprintf("no repository matching '%v' on root node %v", pattern, s.PulpRootNode.Fqdn)
+				return repositories, errors.New(errorMsg)
+			}
+		}
+	}
+	return
+}
+
+// Compare the parents and tags of the nodes with another stage
+func (s *Stage) Compare(other *Stage) (differences []string) {
+	nodes := make(map[string]*Node)
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		nodes[n.Fqdn] = n
+	})
+
+	otherNodes := make(map[string]*Node)
+	other.NodeTreeWalker(other.PulpRootNode, func(n *Node) {
+		otherNodes[n.Fqdn] = n
+		node, exists := nodes[n.Fqdn]
+		if !exists {
+			differences = append(differences, fmt.Sprintf("stage '%v': node %v only in %v", s.Name, n.Fqdn, other.File))
+			return
+		}
+		if node.parentFqdn() != n.parentFqdn() {
+			differences = append(differences, fmt.Sprintf("stage '%v': node %v has parent '%v' in %v and '%v' in %v",
+				s.Name, n.Fqdn, node.parentFqdn(), s.File, n.parentFqdn(), other.File))
+		}
+		if !sameTags(node.Tags, n.Tags) {
+			differences = append(differences, fmt.Sprintf("stage '%v': node %v has tags %v in %v and %v in %v",
+				s.Name, n.Fqdn, node.Tags, s.File, n.Tags, other.File))
+		}
+	})
+
+	s.NodeTreeWalker(s.PulpRootNode, func(n *Node) {
+		if _, exists := otherNodes[n.Fqdn]; !exists {
+			differences = append(differences, fmt.Sprintf("stage '%v': node %v only in %v", s.Name, n.Fqdn, s.File))
+		}
+	})
+	return
+}
+
 // get a filtered stage.
 func (s *Stage) Filter(nodeFqdns []string, nodeTags []string) (filteredStage *Stage) {
+	// make sure inherited tags are known before filtering
+	s.Init()
 	filteredStage = s
 	s.NodeTreeWalker(filteredStage.PulpRootNode, func(n *Node) {
 		childsToKeep := []*Node{}
@@ -176,3 +368,43 @@ func (s *Stage) Filter(nodeFqdns []string, nodeTags []string) (filteredStage *St
 	})
 	return filteredStage
 }
+
+// Copy the stage configuration, without the state of previous runs
+func (s *Stage) Copy() *Stage {
+	copied := *s
+	copied.PulpRootNode = s.PulpRootNode.Copy()
+	copied.Leafs = nil
+	copied.Nodes = nil
+	copied.Init()
+	return &copied
+}
+
+func appendUnique(slice []string, element string) []string {
+	for _, elem := range slice {
+		if elem == element {
+			return slice
+		}
+	}
+	return append(slice, element)
+}
+
+func sameTags(tags []string, otherTags []string) bool {
+	if len(tags) != len(otherTags) {
+		return false
+	}
+	for _, tag := range tags {
+		if posString(otherTags, tag) == -1 {
+			return false
+		}
+	}
+	return true
+}
+
+func posString(slice []string, element string) int {
+	for index, elem := range slice {
+		if elem == element {
+			return index
+		}
+	}
+	return -1
+}
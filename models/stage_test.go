@@ -0,0 +1,90 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+// a stage with the tree root -> (dc1 -> dmz1, dc2)
+func newTestStage() *Stage {
+	s := &Stage{
+		Name: "test",
+		PulpRootNode: &Node{
+			Fqdn: "root",
+			Children: []*Node{
+				{Fqdn: "dc1", Children: []*Node{{Fqdn: "dmz1"}}},
+				{Fqdn: "dc2"},
+			},
+		},
+	}
+	s.Init()
+	return s
+}
+
+func TestStageInitResetsLastPass(t *testing.T) {
+	s := newTestStage()
+	dc1 := s.GetNodeByFqdn("dc1")
+	dc1.RepositoryError["rhel7-os"] = errors.New("sync failed")
+	dc1.Errors = append(dc1.Errors, errors.New("no live workers"))
+	dc1.Repositories = append(dc1.Repositories, Repository{Name: "rhel7-os"})
+	dc1.PreflightFailed = true
+
+	s.Init()
+	if len(dc1.RepositoryError) != 0 {
+		t.Errorf("the repository errors of the last pass are kept: %v", dc1.RepositoryError)
+	}
+	if len(dc1.Errors) != 0 {
+		t.Errorf("the node errors of the last pass are kept: %v", dc1.Errors)
+	}
+	if dc1.PreflightFailed {
+		t.Errorf("the preflight failure of the last pass is kept")
+	}
+
+}
+
+func TestStageRepeatedPasses(t *testing.T) {
+	ActiveSimulation = NewSimulation(SimulationSettings{}, 1000, 1)
+	defer func() { ActiveSimulation = nil }()
+	ActiveSimulation.AddRepositories([]string{"rhel7-os", "rhel7-updates"})
+
+	s := newTestStage()
+	dc1 := s.GetNodeByFqdn("dc1")
+	for pass := 1; pass <= 3; pass++ {
+		s.UpdateLive()
+		if len(dc1.Repositories) != 2 {
+			t.Errorf("pass %v: expected 2 repositories, got %v", pass, len(dc1.Repositories))
+		}
+		if dc1.HasError() {
+			t.Errorf("pass %v: unexpected errors %v %v", pass, dc1.Errors, dc1.RepositoryError)
+		}
+	}
+}
+
+func TestSyncKeepsPreflightErrors(t *testing.T) {
+	s := newTestStage()
+	dc1 := s.GetNodeByFqdn("dc1")
+	dc1.RepositoryError["rhel7-os"] = errors.New("repository 'rhel7-os' does not exist on node dc1")
+	dc1.Errors = append(dc1.Errors, errors.New("no live workers"))
+	dc1.failPreflight()
+
+	// the sync initializes the stage again, then skips the failing subtree
+	s.Init()
+	progress := make(chan SyncProgress, 10)
+	for _, n := range []*Node{dc1, s.GetNodeByFqdn("dmz1")} {
+		if err := n.Sync([]string{"rhel7-os"}, progress); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(progress)
+
+	if dc1.RepositoryError["rhel7-os"] == nil || len(dc1.Errors) != 1 || !dc1.PreflightFailed {
+		t.Errorf("the preflight errors of dc1 are lost")
+	}
+	states := make(map[string]string)
+	for sp := range progress {
+		states[sp.Node.Fqdn] = sp.State
+	}
+	if states["dc1"] != "skipped" || states["dmz1"] != "skipped" {
+		t.Errorf("expected dc1 and dmz1 to skip the sync, got %v", states)
+	}
+}
@@ -1,10 +1,31 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+)
+
 type StageTree struct {
-	Description string
-	ApiUser     string
-	ApiPasswd   string
-	Stages      []*Stage
+	Description    string                 `yaml:"description,omitempty"`
+	ApiUser        string                 `yaml:"apiuser,omitempty"`
+	ApiPasswd      string                 `yaml:"apipasswd,omitempty"`
+	Include        []string               `yaml:"-"`
+	InheritTags    bool                   `mapstructure:"inherit_tags" yaml:"inherit_tags,omitempty"`
+	TagSettings    map[string]*TagSetting `mapstructure:"tag_settings" yaml:"tag_settings,omitempty"`
+	RepositorySets map[string][]string    `mapstructure:"repository_sets" yaml:"repository_sets,omitempty"`
+	Templates      map[string]interface{} `yaml:"-"`
+	Schedules      []*Schedule            `yaml:"schedules,omitempty"`
+	Notifications  []*Notification        `yaml:"notifications,omitempty"`
+	Simulation     SimulationSettings     `yaml:"simulation,omitempty"`
+	Stages         []*Stage               `yaml:"stages"`
+}
+
+// Link the stages to the tree and initialize them
+func (st *StageTree) Init() {
+	for _, stage := range st.Stages {
+		stage.Tree = st
+		stage.Init()
+	}
 }
 
 func (st StageTree) GetStageByName(name string) (outStage *Stage) {
@@ -15,3 +36,83 @@ func (st StageTree) GetStageByName(name string) (outStage *Stage) {
 	}
 	return outStage
 }
+
+// Copy the tree with the passwords and notification secrets redacted, for printing
+func (st *StageTree) Redacted() *StageTree {
+	copied := *st
+	copied.ApiPasswd = redactedSecret(st.ApiPasswd)
+
+	copied.TagSettings = make(map[string]*TagSetting)
+	for tag, setting := range st.TagSettings {
+		copiedSetting := *setting
+		copiedSetting.ApiPasswd = redactedSecret(setting.ApiPasswd)
+		copied.TagSettings[tag] = &copiedSetting
+	}
+
+	copied.Notifications = nil
+	for _, notification := range st.Notifications {
+		copiedNotification := *notification
+		copiedNotification.Secret = redactedSecret(notification.Secret)
+		copied.Notifications = append(copied.Notifications, &copiedNotification)
+	}
+
+	copied.Stages = nil
+	for _, stage := range st.Stages {
+		copiedStage := *stage
+		copiedStage.PulpRootNode = stage.PulpRootNode.Copy()
+		copiedStage.Tree = &copied
+		copiedStage.Leafs = nil
+		copiedStage.Nodes = nil
+		copiedStage.NodeTreeWalker(copiedStage.PulpRootNode, func(n *Node) {
+			n.ApiPasswd = redactedSecret(n.ApiPasswd)
+		})
+		copied.Stages = append(copied.Stages, &copiedStage)
+	}
+	return &copied
+}
+
+// an unset secret stays unset
+func redactedSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// Get the settings of the given tag
+func (st *StageTree) GetTagSetting(tag string) *TagSetting {
+	if st == nil {
+		return nil
+	}
+	return st.TagSettings[tag]
+}
+
+// Get the repositories of the given named set
+func (st *StageTree) GetRepositorySet(name string) (repositories []string, err error) {
+	if st != nil {
+		if set, exists := st.RepositorySets[name]; exists {
+			return set, nil
+		}
+	}
+	errorMsg := fmt.Sprintf("repository set '%v' is not defined", name)
+	return repositories, errors.New(errorMsg)
+}
+
+// Compare the stages, parents and tags of the nodes with another tree.
+// Returns a description of each difference.
+func (st *StageTree) Compare(other *StageTree) (differences []string) {
+	for _, stage := range st.Stages {
+		otherStage := other.GetStageByName(stage.Name)
+		if otherStage == nil {
+			differences = append(differences, fmt.Sprintf("stage '%v': only in %v", stage.Name, stage.File))
+			continue
+		}
+		differences = append(differences, stage.Compare(otherStage)...)
+	}
+	for _, otherStage := range other.Stages {
+		if st.GetStageByName(otherStage.Name) == nil {
+			differences = append(differences, fmt.Sprintf("stage '%v': only in %v", otherStage.Name, otherStage.File))
+		}
+	}
+	return
+}
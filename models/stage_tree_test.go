@@ -0,0 +1,58 @@
+package models
+
+import (
+	"gopkg.in/yaml.v2"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStageTreeRedacted(t *testing.T) {
+	os.Setenv("NODETREE_TEST_SECRET", "env-s3cret")
+	defer os.Unsetenv("NODETREE_TEST_SECRET")
+
+	st, err := decodeYaml(t, `
+apiuser: admin
+apipasswd: tree-s3cret
+tag_settings:
+  dmz:
+    apipasswd: tag-s3cret
+notifications:
+  - url: https://hooks.example.com/nodetree
+    secret: '${NODETREE_TEST_SECRET}'
+stages:
+  - name: lab
+    pulprootnode:
+      fqdn: root.example.com
+      apipasswd: root-s3cret
+      children:
+        - fqdn: dmz1.example.com
+          tags: [dmz]
+          apipasswd: node-s3cret
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := yaml.Marshal(st.Redacted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"tree-s3cret", "tag-s3cret", "env-s3cret", "root-s3cret", "node-s3cret"} {
+		if strings.Contains(string(out), secret) {
+			t.Errorf("the secret '%v' is printed:\n%s", secret, out)
+		}
+	}
+	for _, kept := range []string{"apiuser: admin", "fqdn: dmz1.example.com", "url: https://hooks.example.com/nodetree"} {
+		if !strings.Contains(string(out), kept) {
+			t.Errorf("'%v' is missing:\n%s", kept, out)
+		}
+	}
+
+	// the tree itself keeps its secrets
+	if st.ApiPasswd != "tree-s3cret" || st.TagSettings["dmz"].ApiPasswd != "tag-s3cret" ||
+		st.Notifications[0].Secret != "env-s3cret" ||
+		st.Stages[0].PulpRootNode.Children[0].ApiPasswd != "node-s3cret" {
+		t.Errorf("the secrets of the tree are redacted")
+	}
+}
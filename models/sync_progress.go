@@ -2,17 +2,97 @@ package models
 
 import (
 	"math"
+	"time"
 )
 
 type SyncProgress struct {
 	Node       *Node
 	Repository string
+	TaskId     string
 	State      string
 	SizeTotal  int
 	SizeLeft   int
 	ItemsTotal int
 	ItemsLeft  int
 	Message    string
+	Error      error
+	// the error is of the node, e.g. unreachable, and is sent for each repository
+	NodeError bool
+	// bytes per second, smoothed while running, the average once finished
+	Throughput float64
+	// the estimated remaining time of the repository on the node, 0 if unknown
+	Eta time.Duration
+	// the estimated remaining time of the whole stage, 0 if unknown
+	StageEta time.Duration
+}
+
+// A sync progress event without the node references, for encoding
+type ProgressEvent struct {
+	Fqdn       string
+	Repository string
+	TaskId     string `json:",omitempty"`
+	State      string
+	SizeTotal  int
+	SizeLeft   int
+	ItemsTotal int
+	ItemsLeft  int
+	Message    string
+	Error      string `json:",omitempty"`
+	// bytes per second
+	Throughput      float64 `json:",omitempty"`
+	EtaSeconds      float64 `json:",omitempty"`
+	StageEtaSeconds float64 `json:",omitempty"`
+}
+
+func (s *SyncProgress) Event() ProgressEvent {
+	event := ProgressEvent{
+		Fqdn:            s.Node.Fqdn,
+		Repository:      s.Repository,
+		TaskId:          s.TaskId,
+		State:           s.State,
+		SizeTotal:       s.SizeTotal,
+		SizeLeft:        s.SizeLeft,
+		ItemsTotal:      s.ItemsTotal,
+		ItemsLeft:       s.ItemsLeft,
+		Message:         s.Message,
+		Throughput:      s.Throughput,
+		EtaSeconds:      s.Eta.Seconds(),
+		StageEtaSeconds: s.StageEta.Seconds(),
+	}
+	if s.Error != nil {
+		event.Error = s.Error.Error()
+	}
+	return event
+}
+
+// Log the progress event and pass it on
+func (n *Node) sendProgress(progressChannel chan SyncProgress, sp SyncProgress) {
+	entry := n.logger().With("repository", sp.Repository)
+	if sp.TaskId != "" {
+		entry = entry.With("task", sp.TaskId)
+	}
+
+	if n.syncStates == nil {
+		n.syncStates = make(map[string]string)
+	}
+	previous := n.syncStates[sp.Repository]
+	n.syncStates[sp.Repository] = sp.State
+
+	switch {
+	case sp.State == "error":
+		entry.With("error", sp.Error).Errorf("sync failed")
+	case sp.State == "skipped":
+		entry.Warningf("sync skipped: %v", sp.Message)
+	case sp.State != previous:
+		entry.With("previous", previous).Infof("sync state changed to %v", sp.State)
+	default:
+		entry.With("items_left", sp.ItemsLeft).With("size_left", sp.SizeLeft).Tracef("task polled")
+	}
+
+	// the policy sees the failure before any other sync can start,
+	// whatever the time the event takes to be received
+	n.policy.Observe(sp)
+	progressChannel <- sp
 }
 
 func (s *SyncProgress) ItemsDone() int {
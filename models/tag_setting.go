@@ -0,0 +1,94 @@
+package models
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/mreiferson/go-httpclient"
+	"io/ioutil"
+	"time"
+)
+
+// default timeouts of the pulp api client, in seconds
+const (
+	defaultConnectTimeout        = 1
+	defaultResponseHeaderTimeout = 10
+	defaultRequestTimeout        = 30
+)
+
+// Settings carried by a tag. They apply to every node carrying the tag.
+type TagSetting struct {
+	ApiUser               string           `yaml:"apiuser,omitempty"`
+	ApiPasswd             string           `yaml:"apipasswd,omitempty"`
+	ConnectTimeout        int              `mapstructure:"connect_timeout" yaml:"connect_timeout,omitempty"`
+	ResponseHeaderTimeout int              `mapstructure:"response_header_timeout" yaml:"response_header_timeout,omitempty"`
+	RequestTimeout        int              `mapstructure:"request_timeout" yaml:"request_timeout,omitempty"`
+	Ssl                   bool             `mapstructure:"ssl" yaml:"ssl,omitempty"`
+	SslInsecure           bool             `mapstructure:"ssl_insecure" yaml:"ssl_insecure,omitempty"`
+	SslCaFile             string           `mapstructure:"ssl_ca_file" yaml:"ssl_ca_file,omitempty"`
+	Repositories          RepositoryFilter `yaml:"repositories,omitempty"`
+}
+
+// Merge the given setting into this one. Values already set are kept.
+func (t *TagSetting) Merge(other *TagSetting) {
+	if other == nil {
+		return
+	}
+	if t.ApiUser == "" {
+		t.ApiUser = other.ApiUser
+	}
+	if t.ApiPasswd == "" {
+		t.ApiPasswd = other.ApiPasswd
+	}
+	if t.ConnectTimeout == 0 {
+		t.ConnectTimeout = other.ConnectTimeout
+	}
+	if t.ResponseHeaderTimeout == 0 {
+		t.ResponseHeaderTimeout = other.ResponseHeaderTimeout
+	}
+	if t.RequestTimeout == 0 {
+		t.RequestTimeout = other.RequestTimeout
+	}
+	if t.SslCaFile == "" {
+		t.SslCaFile = other.SslCaFile
+	}
+	t.Ssl = t.Ssl || other.Ssl
+	t.SslInsecure = t.SslInsecure || other.SslInsecure
+}
+
+// Build the http transport for the pulp api client
+func (t *TagSetting) Transport() (transport *httpclient.Transport, err error) {
+	transport = &httpclient.Transport{
+		ConnectTimeout:        secondsOrDefault(t.ConnectTimeout, defaultConnectTimeout),
+		ResponseHeaderTimeout: secondsOrDefault(t.ResponseHeaderTimeout, defaultResponseHeaderTimeout),
+		RequestTimeout:        secondsOrDefault(t.RequestTimeout, defaultRequestTimeout),
+	}
+
+	if t.SslInsecure || t.SslCaFile != "" {
+		tlsConfig := &tls.Config{
+			InsecureSkipVerify: t.SslInsecure,
+		}
+		if t.SslCaFile != "" {
+			pem, err := ioutil.ReadFile(t.SslCaFile)
+			if err != nil {
+				return transport, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				errorMsg := fmt.Sprintf("no certificate found in ca file '%v'", t.SslCaFile)
+				return transport, errors.New(errorMsg)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return
+}
+
+func secondsOrDefault(seconds int, defaultSeconds int) time.Duration {
+	if seconds == 0 {
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
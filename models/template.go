@@ -0,0 +1,127 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"os"
+	"regexp"
+)
+
+// matches ${name}
+var variablePattern = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+
+// Decode the raw settings of a configuration file into the stage tree.
+// Environment variables are interpolated in all values except in the
+// templates, which are interpolated when instantiated by a stage.
+func DecodeStageTree(settings map[string]interface{}, st *StageTree) (err error) {
+	interpolated := make(map[string]interface{})
+	for key, value := range settings {
+		if key == "templates" {
+			interpolated[key] = value
+			continue
+		}
+		interpolated[key], err = Interpolate(value, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return mapstructure.WeakDecode(interpolated, st)
+}
+
+// Instantiate the templates of the stages not defining their own root node
+func (st *StageTree) ExpandTemplates() (err error) {
+	for _, stage := range st.Stages {
+		if stage.Template == "" {
+			continue
+		}
+		if stage.PulpRootNode != nil {
+			errorMsg := fmt.Sprintf("%v: stage '%v' defines both a template and a root node", stage.Location(), stage.Name)
+			return errors.New(errorMsg)
+		}
+
+		template, exists := st.Templates[stage.Template]
+		if !exists {
+			errorMsg := fmt.Sprintf("%v: template '%v' of stage '%v' is not defined", stage.Location(), stage.Template, stage.Name)
+			return errors.New(errorMsg)
+		}
+
+		// the stage name is always available as ${stage}
+		vars := map[string]string{"stage": stage.Name}
+		for name, value := range stage.Vars {
+			vars[name] = value
+		}
+
+		raw, err := Interpolate(template, vars)
+		if err != nil {
+			errorMsg := fmt.Sprintf("%v: stage '%v': %v", stage.Location(), stage.Name, err)
+			return errors.New(errorMsg)
+		}
+
+		stage.PulpRootNode = &Node{}
+		err = mapstructure.WeakDecode(raw, stage.PulpRootNode)
+		if err != nil {
+			errorMsg := fmt.Sprintf("%v: stage '%v': %v", stage.Location(), stage.Name, err)
+			return errors.New(errorMsg)
+		}
+	}
+	return
+}
+
+// Replace the ${name} variables in all string values of a raw yaml structure.
+// Variables are looked up in vars first, then in the environment.
+// The structure is copied, the given value is not modified.
+func Interpolate(value interface{}, vars map[string]string) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return interpolateString(v, vars)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			interpolated, err := Interpolate(elem, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = interpolated
+		}
+		return out, nil
+	case map[interface{}]interface{}:
+		out := make(map[interface{}]interface{})
+		for key, elem := range v {
+			interpolated, err := Interpolate(elem, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = interpolated
+		}
+		return out, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		for key, elem := range v {
+			interpolated, err := Interpolate(elem, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = interpolated
+		}
+		return out, nil
+	}
+	return value, nil
+}
+
+func interpolateString(value string, vars map[string]string) (string, error) {
+	var err error
+	out := variablePattern.ReplaceAllStringFunc(value, func(match string) string {
+		name := variablePattern.FindStringSubmatch(match)[1]
+		if varValue, exists := vars[name]; exists {
+			return varValue
+		}
+		if envValue, exists := os.LookupEnv(name); exists {
+			return envValue
+		}
+		errorMsg := fmt.Sprintf("undefined variable '%v' in '%v'", name, value)
+		err = errors.New(errorMsg)
+		return match
+	})
+	return out, err
+}
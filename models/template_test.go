@@ -0,0 +1,190 @@
+package models
+
+import (
+	"bytes"
+	"github.com/spf13/viper"
+	"os"
+	"strings"
+	"testing"
+)
+
+// decode a yaml configuration the way the config files are read
+func decodeYaml(t *testing.T, content string) (st StageTree, err error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewBufferString(content)); err != nil {
+		t.Fatalf("invalid yaml fixture: %v", err)
+	}
+	err = DecodeStageTree(v.AllSettings(), &st)
+	return
+}
+
+func TestDecodeStageTreeEnvironment(t *testing.T) {
+	os.Setenv("NODETREE_TEST_PASSWD", "s3cret")
+	defer os.Unsetenv("NODETREE_TEST_PASSWD")
+
+	st, err := decodeYaml(t, `
+apipasswd: '${NODETREE_TEST_PASSWD}'
+stages:
+  - name: lab
+    pulprootnode:
+      fqdn: 'pulp-${NODETREE_TEST_PASSWD}.example.com'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.ApiPasswd != "s3cret" {
+		t.Errorf("apipasswd: got '%v', want 's3cret'", st.ApiPasswd)
+	}
+	if fqdn := st.Stages[0].PulpRootNode.Fqdn; fqdn != "pulp-s3cret.example.com" {
+		t.Errorf("fqdn: got '%v', want 'pulp-s3cret.example.com'", fqdn)
+	}
+}
+
+func TestDecodeStageTreeUnsetVariable(t *testing.T) {
+	os.Unsetenv("NODETREE_TEST_UNSET")
+
+	_, err := decodeYaml(t, `
+stages:
+  - name: lab
+    pulprootnode:
+      fqdn: '${NODETREE_TEST_UNSET}.example.com'
+`)
+	if err == nil {
+		t.Fatal("expected an error for the unset variable")
+	}
+	if !strings.Contains(err.Error(), "undefined variable 'NODETREE_TEST_UNSET'") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDecodeStageTreeTemplatesKeepVariables(t *testing.T) {
+	// templates are interpolated when instantiated, ${stage} is not an environment variable
+	st, err := decodeYaml(t, `
+templates:
+  standard:
+    fqdn: 'pulp-${stage}-1.example.com'
+stages:
+  - name: dev
+    template: standard
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = st.ExpandTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if fqdn := st.Stages[0].PulpRootNode.Fqdn; fqdn != "pulp-dev-1.example.com" {
+		t.Errorf("fqdn: got '%v', want 'pulp-dev-1.example.com'", fqdn)
+	}
+}
+
+func TestExpandNestedTemplate(t *testing.T) {
+	st, err := decodeYaml(t, `
+templates:
+  standard:
+    fqdn: 'pulp-${stage}-1.${domain}'
+    tags: ['${stage}']
+    children:
+      - fqdn: 'pulp-${stage}-2.${domain}'
+        children:
+          - fqdn: 'pulp-${stage}-3.${domain}'
+stages:
+  - name: dev
+    template: standard
+    vars:
+      domain: test
+  - name: prd
+    template: standard
+    vars:
+      domain: example.com
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = st.ExpandTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		stage string
+		fqdns []string
+	}{
+		{"dev", []string{"pulp-dev-1.test", "pulp-dev-2.test", "pulp-dev-3.test"}},
+		{"prd", []string{"pulp-prd-1.example.com", "pulp-prd-2.example.com", "pulp-prd-3.example.com"}},
+	}
+	for _, test := range tests {
+		stage := st.GetStageByName(test.stage)
+		node := stage.PulpRootNode
+		for depth, want := range test.fqdns {
+			if node == nil {
+				t.Fatalf("%v: no node at depth %v", test.stage, depth)
+			}
+			if node.Fqdn != want {
+				t.Errorf("%v: got '%v' at depth %v, want '%v'", test.stage, node.Fqdn, depth, want)
+			}
+			if len(node.Children) == 0 {
+				node = nil
+			} else {
+				node = node.Children[0]
+			}
+		}
+		if tags := stage.PulpRootNode.Tags; len(tags) != 1 || tags[0] != test.stage {
+			t.Errorf("%v: got tags %v", test.stage, tags)
+		}
+	}
+}
+
+func TestExpandTemplateUndefinedVariable(t *testing.T) {
+	st, err := decodeYaml(t, `
+templates:
+  standard:
+    fqdn: 'pulp-${stage}-1.${domain}'
+stages:
+  - name: dev
+    template: standard
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.ExpandTemplates()
+	if err == nil || !strings.Contains(err.Error(), "undefined variable 'domain'") {
+		t.Errorf("expected an undefined variable error, got %v", err)
+	}
+}
+
+func TestDecodeStageTreeWeakTypes(t *testing.T) {
+	st, err := decodeYaml(t, `
+apipasswd: 1234
+tag_settings:
+  '12MZ':
+    connect_timeout: '5'
+stages:
+  - name: 2016
+    vars:
+      release: 7
+    pulprootnode:
+      fqdn: 10
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stage := st.Stages[0]
+	tests := []struct {
+		field string
+		got   interface{}
+		want  interface{}
+	}{
+		{"apipasswd", st.ApiPasswd, "1234"},
+		{"stage name", stage.Name, "2016"},
+		{"vars", stage.Vars["release"], "7"},
+		{"fqdn", stage.PulpRootNode.Fqdn, "10"},
+		{"connect_timeout", st.TagSettings["12MZ"].ConnectTimeout, 5},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%v: got %#v, want %#v", test.field, test.got, test.want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/msutter/nodetree/models"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// the number of runs listed without a limit parameter
+const defaultRunsLimit = 100
+
+// The HTTP API to list the stages and to start and follow runs
+type API struct {
+	Runner *Runner
+	Token  string
+	broker *Broker
+	mux    *http.ServeMux
+}
+
+// A request to start a run
+type RunRequest struct {
+	Command      string
+	Stage        string
+	Repositories []string
+	Fqdns        []string
+	Tags         []string
+}
+
+// A node of a stage tree, for encoding
+type TreeNode struct {
+	Fqdn     string
+	Tags     []string    `json:",omitempty"`
+	Children []*TreeNode `json:",omitempty"`
+}
+
+type apiError struct {
+	Error string
+}
+
+// Build the API for the runner. The token is required on each request.
+func NewAPI(runner *Runner, token string) *API {
+	api := &API{
+		Runner: runner,
+		Token:  token,
+		broker: NewBroker(),
+		mux:    http.NewServeMux(),
+	}
+
+	runner.ProgressHandlers = append(runner.ProgressHandlers, func(run *models.Run, sp models.SyncProgress) {
+		api.broker.Publish(run.Id, sp.Event())
+	})
+	runner.FinishHandlers = append(runner.FinishHandlers, func(run *models.Run) {
+		api.broker.Close(run.Id)
+	})
+
+	api.mux.HandleFunc("/api/stages", api.handleStages)
+	api.mux.HandleFunc("/api/stages/", api.handleStage)
+	api.mux.HandleFunc("/api/runs", api.handleRuns)
+	api.mux.HandleFunc("/api/runs/", api.handleRun)
+	return api
+}
+
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if api.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(api.Token)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid or missing token")
+		return
+	}
+	api.mux.ServeHTTP(w, r)
+}
+
+// Serve the API on the address in the background
+func Listen(address string, api *API) (err error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	go http.Serve(listener, api)
+	return
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(content, '\n'))
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+func treeNode(n *models.Node) *TreeNode {
+	tn := &TreeNode{
+		Fqdn: n.Fqdn,
+		Tags: n.Tags,
+	}
+	for _, child := range n.Children {
+		tn.Children = append(tn.Children, treeNode(child))
+	}
+	return tn
+}
+
+// GET /api/stages
+func (api *API) handleStages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	names := []string{}
+	for _, stage := range api.Runner.Tree.Stages {
+		names = append(names, stage.Name)
+	}
+	writeJSON(w, http.StatusOK, names)
+}
+
+// GET /api/stages/<name>
+func (api *API) handleStage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/stages/")
+	stage := api.Runner.Tree.GetStageByName(name)
+	if stage == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("stage '%v' not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, treeNode(stage.PulpRootNode))
+}
+
+// GET /api/runs lists the last runs, newest first, POST /api/runs starts a run
+func (api *API) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		limit := defaultRunsLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit '%v'", value))
+				return
+			}
+		}
+
+		// the running runs are not saved yet
+		runs := []*models.Run{}
+		for _, run := range api.Runner.Runs() {
+			if !run.IsDone() {
+				runs = append([]*models.Run{run}, runs...)
+			}
+		}
+		saved, err := models.LoadRuns(limit, nil)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		runs = append(runs, saved...)
+		if limit > 0 && len(runs) > limit {
+			runs = runs[:limit]
+		}
+		writeJSON(w, http.StatusOK, runs)
+	case "POST":
+		var request RunRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if request.Command == "" {
+			request.Command = "sync"
+		}
+		if request.Command != "sync" && request.Command != "check" {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown command '%v'", request.Command))
+			return
+		}
+		if api.Runner.Tree.GetStageByName(request.Stage) == nil {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("stage '%v' not found", request.Stage))
+			return
+		}
+		if len(request.Repositories) == 0 {
+			writeError(w, http.StatusBadRequest, "a run needs repositories")
+			return
+		}
+
+		run := models.NewRun(request.Command, request.Stage)
+		run.Trigger = "api"
+		run.Repositories = request.Repositories
+		run.Fqdns = request.Fqdns
+		run.Tags = request.Tags
+		err = api.Runner.Start(run)
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusAccepted, run)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// GET /api/runs/<id> and GET /api/runs/<id>/events
+func (api *API) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api/runs/")
+	id := strings.TrimSuffix(path, "/events")
+
+	// run ids name the saved files
+	if strings.ContainsAny(id, "/\\") || strings.HasPrefix(id, ".") {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("run '%v' not found", id))
+		return
+	}
+	run, err := api.Runner.GetRun(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("run '%v' not found", id))
+		return
+	}
+
+	if strings.HasSuffix(path, "/events") {
+		api.streamEvents(w, r, run)
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+// Stream the progress events of the run as server-sent events,
+// ending with a 'run' event holding the final run
+func (api *API) streamEvents(w http.ResponseWriter, r *http.Request, run *models.Run) {
+	// a wrapped response writer may support neither
+	flusher, canFlush := w.(http.Flusher)
+	notifier, canNotify := w.(http.CloseNotifier)
+	if !canFlush || !canNotify {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	events := api.broker.Subscribe(run.Id)
+	// a run ending before the subscription has no more events
+	if run.IsDone() {
+		api.broker.Unsubscribe(run.Id, events)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	closed := notifier.CloseNotify()
+	for {
+		select {
+		case event, open := <-events:
+			if !open {
+				writeEvent(w, "run", run)
+				flusher.Flush()
+				return
+			}
+			writeEvent(w, "progress", event)
+			flusher.Flush()
+		case <-closed:
+			api.broker.Unsubscribe(run.Id, events)
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, name string, value interface{}) {
+	data, _ := json.Marshal(value)
+	fmt.Fprintf(w, "event: %v\ndata: %s\n\n", name, data)
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A parsed cron expression: minute, hour, day of month, month and day of week
+type CronSchedule struct {
+	Expression string
+	minutes    map[int]bool
+	hours      map[int]bool
+	days       map[int]bool
+	months     map[int]bool
+	weekdays   map[int]bool
+	// day of month and day of week match if either matches, unless one is '*'
+	anyDay     bool
+	anyWeekday bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var weekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// Parse a five field cron expression or one of the @ macros
+func ParseCron(expression string) (cs *CronSchedule, err error) {
+	spec := strings.TrimSpace(expression)
+	if macro, exists := cronMacros[spec]; exists {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		errorMsg := fmt.Sprintf("cron expression '%v' needs 5 fields, got %v", expression, len(fields))
+		return nil, errors.New(errorMsg)
+	}
+
+	cs = &CronSchedule{
+		Expression: expression,
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}
+	if cs.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, cronError(expression, "minute", err)
+	}
+	if cs.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, cronError(expression, "hour", err)
+	}
+	if cs.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, cronError(expression, "day of month", err)
+	}
+	if cs.months, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, cronError(expression, "month", err)
+	}
+	if cs.weekdays, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return nil, cronError(expression, "day of week", err)
+	}
+	// 7 is sunday too
+	if cs.weekdays[7] {
+		cs.weekdays[0] = true
+	}
+	return
+}
+
+func cronError(expression string, field string, err error) error {
+	errorMsg := fmt.Sprintf("cron expression '%v': %v field: %v", expression, field, err)
+	return errors.New(errorMsg)
+}
+
+// Parse a comma separated list of '*', values and ranges with optional steps
+func parseCronField(field string, min int, max int, names map[string]int) (values map[int]bool, err error) {
+	values = make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				errorMsg := fmt.Sprintf("invalid step '%v'", part[i+1:])
+				return nil, errors.New(errorMsg)
+			}
+			part = part[:i]
+		}
+
+		from, to := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			if from, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return nil, err
+			}
+			if to, err = parseCronValue(bounds[1], min, max, names); err != nil {
+				return nil, err
+			}
+			if to < from {
+				errorMsg := fmt.Sprintf("invalid range '%v'", part)
+				return nil, errors.New(errorMsg)
+			}
+		default:
+			if from, err = parseCronValue(part, min, max, names); err != nil {
+				return nil, err
+			}
+			// a single value with a step runs up to the max
+			if step == 1 {
+				to = from
+			}
+		}
+
+		for value := from; value <= to; value += step {
+			values[value] = true
+		}
+	}
+	return
+}
+
+func parseCronValue(value string, min int, max int, names map[string]int) (int, error) {
+	if named, exists := names[strings.ToLower(value)]; exists {
+		return named, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		errorMsg := fmt.Sprintf("value '%v' out of range %v-%v", value, min, max)
+		return 0, errors.New(errorMsg)
+	}
+	return number, nil
+}
+
+// Does the schedule match the minute of the given time?
+func (cs *CronSchedule) Matches(t time.Time) bool {
+	if !cs.minutes[t.Minute()] || !cs.hours[t.Hour()] || !cs.months[int(t.Month())] {
+		return false
+	}
+	return cs.dayMatches(t)
+}
+
+// Get the next time after the given time the schedule matches.
+// Returns the zero time if there is none within 5 years (e.g. February 30).
+func (cs *CronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !cs.months[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !cs.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !cs.hours[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !cs.minutes[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (cs *CronSchedule) dayMatches(t time.Time) bool {
+	dayMatches := cs.days[t.Day()]
+	weekdayMatches := cs.weekdays[int(t.Weekday())]
+	switch {
+	case cs.anyDay && cs.anyWeekday:
+		return true
+	case cs.anyDay:
+		return weekdayMatches
+	case cs.anyWeekday:
+		return dayMatches
+	default:
+		return dayMatches || weekdayMatches
+	}
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func sortedValues(values map[int]bool) (sorted []int) {
+	for value := range values {
+		sorted = append(sorted, value)
+	}
+	sort.Ints(sorted)
+	return
+}
+
+func sameValues(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field  string
+		min    int
+		max    int
+		names  map[string]int
+		values []int
+	}{
+		{"*", 0, 6, nil, []int{0, 1, 2, 3, 4, 5, 6}},
+		{"5", 0, 59, nil, []int{5}},
+		{"1,15,30", 0, 59, nil, []int{1, 15, 30}},
+		{"8-11", 0, 23, nil, []int{8, 9, 10, 11}},
+		{"*/15", 0, 59, nil, []int{0, 15, 30, 45}},
+		{"10-20/5", 0, 59, nil, []int{10, 15, 20}},
+		{"50/5", 0, 59, nil, []int{50, 55}},
+		{"1-3,20-21", 1, 31, nil, []int{1, 2, 3, 20, 21}},
+		{"jan,Jun-aug", 1, 12, monthNames, []int{1, 6, 7, 8}},
+		{"mon-fri", 0, 7, weekdayNames, []int{1, 2, 3, 4, 5}},
+	}
+	for _, test := range tests {
+		values, err := parseCronField(test.field, test.min, test.max, test.names)
+		if err != nil {
+			t.Errorf("%v: %v", test.field, err)
+			continue
+		}
+		if got := sortedValues(values); !sameValues(got, test.values) {
+			t.Errorf("%v: got %v, want %v", test.field, got, test.values)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"10-5 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@often",
+	}
+	for _, expression := range tests {
+		if _, err := ParseCron(expression); err == nil {
+			t.Errorf("'%v': expected an error", expression)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2016-11-04 is a friday
+	after := time.Date(2016, 11, 4, 10, 30, 20, 0, time.UTC)
+	tests := []struct {
+		expression string
+		next       time.Time
+	}{
+		{"* * * * *", time.Date(2016, 11, 4, 10, 31, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2016, 11, 5, 10, 30, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2016, 11, 4, 10, 45, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2016, 11, 5, 2, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2016, 11, 5, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2016, 11, 4, 11, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2016, 11, 6, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2016, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 8-18/4 * * mon-fri", time.Date(2016, 11, 4, 12, 0, 0, 0, time.UTC)},
+		{"0 20 * * sat,sun", time.Date(2016, 11, 5, 20, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2016, 11, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2016, 12, 31, 0, 0, 0, 0, time.UTC)},
+		// day of month or day of week when both are set
+		{"0 0 13 * fri", time.Date(2016, 11, 11, 0, 0, 0, 0, time.UTC)},
+		{"0 0 5 * mon", time.Date(2016, 11, 5, 0, 0, 0, 0, time.UTC)},
+		// never matches
+		{"0 0 30 feb *", time.Time{}},
+	}
+	for _, test := range tests {
+		cs, err := ParseCron(test.expression)
+		if err != nil {
+			t.Errorf("%v: %v", test.expression, err)
+			continue
+		}
+		if next := cs.Next(after); !next.Equal(test.next) {
+			t.Errorf("%v: got %v, want %v", test.expression, next, test.next)
+		}
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	cs, err := ParseCron("0 2 * * mon-fri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		time    time.Time
+		matches bool
+	}{
+		{time.Date(2016, 11, 4, 2, 0, 0, 0, time.UTC), true},
+		{time.Date(2016, 11, 4, 2, 0, 59, 0, time.UTC), true},
+		{time.Date(2016, 11, 4, 2, 1, 0, 0, time.UTC), false},
+		{time.Date(2016, 11, 4, 3, 0, 0, 0, time.UTC), false},
+		{time.Date(2016, 11, 5, 2, 0, 0, 0, time.UTC), false},
+	}
+	for _, test := range tests {
+		if matches := cs.Matches(test.time); matches != test.matches {
+			t.Errorf("%v: got %v, want %v", test.time, matches, test.matches)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"github.com/msutter/nodetree/models"
+	"sync"
+)
+
+// Passes the progress events of the runs to their subscribers
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[string][]chan models.ProgressEvent
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[string][]chan models.ProgressEvent),
+	}
+}
+
+// Subscribe to the events of the run.
+// The channel is closed when the run ends.
+func (b *Broker) Subscribe(runId string) chan models.ProgressEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan models.ProgressEvent, 256)
+	b.subscribers[runId] = append(b.subscribers[runId], ch)
+	return ch
+}
+
+func (b *Broker) Unsubscribe(runId string, ch chan models.ProgressEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subscribers := b.subscribers[runId]
+	for i, subscriber := range subscribers {
+		if subscriber == ch {
+			b.subscribers[runId] = append(subscribers[:i], subscribers[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+// Pass the event to the subscribers of the run.
+// Slow subscribers miss events instead of blocking the sync.
+func (b *Broker) Publish(runId string, event models.ProgressEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ch := range b.subscribers[runId] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Close the channels of the subscribers of the run
+func (b *Broker) Close(runId string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ch := range b.subscribers[runId] {
+		close(ch)
+	}
+	delete(b.subscribers, runId)
+}
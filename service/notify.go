@@ -0,0 +1,169 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/msutter/nodetree/models"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultNotificationTimeout = 10
+	defaultNotificationRetries = 3
+)
+
+// The JSON body of a webhook delivery
+type NotificationPayload struct {
+	Event           string
+	Node            string `json:",omitempty"`
+	Run             *models.Run
+	DurationSeconds float64
+	Counts          models.RunCounts
+}
+
+// Delivers the notifications of the tree
+type Notifier struct {
+	Notifications []*models.Notification
+	// the delay before the first retry, doubled on each retry
+	RetryDelay time.Duration
+}
+
+func NewNotifier(notifications []*models.Notification) *Notifier {
+	return &Notifier{
+		Notifications: notifications,
+		RetryDelay:    time.Second,
+	}
+}
+
+// Check the notification settings
+func (nf *Notifier) Validate() (err error) {
+	for _, notification := range nf.Notifications {
+		if notification.Url == "" {
+			return errors.New("notification without an url")
+		}
+		if notification.Retries != nil && *notification.Retries < 0 {
+			errorMsg := fmt.Sprintf("notification '%v': invalid retries %v", notification.Url, *notification.Retries)
+			return errors.New(errorMsg)
+		}
+		for _, event := range notification.Events {
+			known := false
+			for _, e := range models.NotificationEvents {
+				if e == event {
+					known = true
+				}
+			}
+			if !known {
+				errorMsg := fmt.Sprintf("notification '%v': unknown event '%v'", notification.Url, event)
+				return errors.New(errorMsg)
+			}
+		}
+	}
+	return
+}
+
+// Build the payloads of the events of the ended run
+func Payloads(run *models.Run) (payloads []*NotificationPayload) {
+	newPayload := func(event string, node string) *NotificationPayload {
+		return &NotificationPayload{
+			Event:           event,
+			Node:            node,
+			Run:             run,
+			DurationSeconds: run.Duration().Seconds(),
+			Counts:          run.Counts(),
+		}
+	}
+
+	payloads = append(payloads, newPayload("run_finished", ""))
+	if run.State == "failed" {
+		payloads = append(payloads, newPayload("run_failed", ""))
+	}
+	for _, fqdn := range run.FailedNodes() {
+		payloads = append(payloads, newPayload("node_failed", fqdn))
+	}
+	return
+}
+
+// Send the events of the ended run to the notifications wanting them.
+// Returns the delivery errors.
+func (nf *Notifier) Notify(run *models.Run) (errs []error) {
+	for _, payload := range Payloads(run) {
+		for _, notification := range nf.Notifications {
+			if !notification.Wants(payload.Event) {
+				continue
+			}
+			err := nf.Deliver(notification, payload)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return
+}
+
+// Post the payload, retrying on failures
+func (nf *Notifier) Deliver(notification *models.Notification, payload *NotificationPayload) (err error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	timeout := notification.Timeout
+	if timeout <= 0 {
+		timeout = defaultNotificationTimeout
+	}
+	retries := defaultNotificationRetries
+	if notification.Retries != nil {
+		retries = *notification.Retries
+	}
+	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+
+	delay := nf.RetryDelay
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		err = post(client, notification, payload.Event, body)
+		if err == nil {
+			return nil
+		}
+	}
+	errorMsg := fmt.Sprintf("notification '%v' of %v failed after %v retries: %v", notification.Url, payload.Event, retries, err)
+	return errors.New(errorMsg)
+}
+
+func post(client *http.Client, notification *models.Notification, event string, body []byte) (err error) {
+	req, err := http.NewRequest("POST", notification.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Nodetree-Event", event)
+	if notification.Secret != "" {
+		req.Header.Set("X-Nodetree-Signature", "sha256="+Sign(notification.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		errorMsg := fmt.Sprintf("unexpected status %v", resp.Status)
+		return errors.New(errorMsg)
+	}
+	return
+}
+
+// Get the hex encoded HMAC-SHA256 signature of the body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"github.com/msutter/nodetree/models"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeliverRetries(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	zero, two := 0, 2
+	tests := []struct {
+		retries  *int
+		attempts int32
+	}{
+		{nil, defaultNotificationRetries + 1},
+		{&zero, 1},
+		{&two, 3},
+	}
+	for _, test := range tests {
+		atomic.StoreInt32(&attempts, 0)
+		notification := &models.Notification{Url: server.URL, Retries: test.retries}
+		notifier := NewNotifier([]*models.Notification{notification})
+		notifier.RetryDelay = time.Millisecond
+
+		err := notifier.Deliver(notification, &NotificationPayload{Event: "run_finished", Run: models.NewRun("sync", "lab")})
+		if err == nil {
+			t.Errorf("expected the delivery to fail")
+		}
+		if n := atomic.LoadInt32(&attempts); n != test.attempts {
+			t.Errorf("expected %v attempt(s), got %v", test.attempts, n)
+		}
+	}
+}
+
+func TestNotifierValidate(t *testing.T) {
+	negative := -1
+	tests := []struct {
+		notification *models.Notification
+		valid        bool
+	}{
+		{&models.Notification{Url: "https://hooks.example.com", Events: []string{"run_failed", "node_failed"}}, true},
+		{&models.Notification{Events: []string{"run_failed"}}, false},
+		{&models.Notification{Url: "https://hooks.example.com", Events: []string{"run_done"}}, false},
+		{&models.Notification{Url: "https://hooks.example.com", Retries: &negative}, false},
+	}
+	for _, test := range tests {
+		err := NewNotifier([]*models.Notification{test.notification}).Validate()
+		if (err == nil) != test.valid {
+			t.Errorf("%v: expected valid %v, got %v", test.notification.Url, test.valid, err)
+		}
+	}
+}
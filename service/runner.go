@@ -0,0 +1,115 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/msutter/nodetree/models"
+	"sync"
+)
+
+// the number of finished runs kept in memory, older runs are loaded from the run store
+const defaultKeptRuns = 100
+
+// Executes runs on the stages of a tree, one run per stage at a time
+type Runner struct {
+	Tree             *models.StageTree
+	ProgressHandlers []func(*models.Run, models.SyncProgress)
+	FinishHandlers   []func(*models.Run)
+	KeptRuns         int
+
+	mu      sync.Mutex
+	running map[string]*models.Run
+	runs    []*models.Run
+}
+
+func NewRunner(st *models.StageTree) *Runner {
+	return &Runner{
+		Tree:     st,
+		KeptRuns: defaultKeptRuns,
+		running:  make(map[string]*models.Run),
+	}
+}
+
+// Start the run in the background.
+// Fails if another run of the same stage is still running.
+func (rn *Runner) Start(run *models.Run) (err error) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if current, exists := rn.running[run.Stage]; exists {
+		errorMsg := fmt.Sprintf("stage '%v' is busy with run %v", run.Stage, current.Id)
+		return errors.New(errorMsg)
+	}
+	rn.running[run.Stage] = run
+	rn.runs = append(rn.runs, run)
+	rn.forgetRuns()
+
+	go rn.execute(run)
+	return
+}
+
+// drop the oldest finished runs above the kept runs
+func (rn *Runner) forgetRuns() {
+	finished := 0
+	for _, run := range rn.runs {
+		if rn.running[run.Stage] != run {
+			finished++
+		}
+	}
+	kept := []*models.Run{}
+	for _, run := range rn.runs {
+		if finished > rn.KeptRuns && rn.running[run.Stage] != run {
+			finished--
+			continue
+		}
+		kept = append(kept, run)
+	}
+	rn.runs = kept
+}
+
+func (rn *Runner) execute(run *models.Run) {
+	defer func() {
+		rn.mu.Lock()
+		delete(rn.running, run.Stage)
+		rn.mu.Unlock()
+	}()
+
+	run.Execute(rn.Tree, func(sp models.SyncProgress) {
+		for _, handler := range rn.ProgressHandlers {
+			handler(run, sp)
+		}
+	})
+	run.Save()
+
+	for _, handler := range rn.FinishHandlers {
+		handler(run)
+	}
+}
+
+// Get the run with the given id, from memory or from the saved runs
+func (rn *Runner) GetRun(id string) (*models.Run, error) {
+	rn.mu.Lock()
+	for _, run := range rn.runs {
+		if run.Id == id {
+			rn.mu.Unlock()
+			return run, nil
+		}
+	}
+	rn.mu.Unlock()
+	return models.LoadRun(id)
+}
+
+// Get the runs started by the runner, with the last finished runs
+func (rn *Runner) Runs() []*models.Run {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	return append([]*models.Run{}, rn.runs...)
+}
+
+// Is a run of the stage running?
+func (rn *Runner) IsRunning(stage string) bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	_, exists := rn.running[stage]
+	return exists
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"github.com/msutter/nodetree/models"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunnerRefusesOverlappingRuns(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "nodetree-runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	viper.Set("state_dir", stateDir)
+	defer viper.Set("state_dir", "")
+
+	// the root node answers once the test releases it, the first run stays busy until then
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	tree := &models.StageTree{
+		Stages: []*models.Stage{
+			{Name: "lab", PulpRootNode: &models.Node{Fqdn: strings.TrimPrefix(server.URL, "http://")}},
+			{Name: "prd", PulpRootNode: &models.Node{Fqdn: strings.TrimPrefix(server.URL, "http://")}},
+		},
+	}
+	tree.Init()
+
+	runner := NewRunner(tree)
+	finished := make(chan *models.Run, 3)
+	runner.FinishHandlers = append(runner.FinishHandlers, func(run *models.Run) {
+		finished <- run
+	})
+
+	// the patterns are expanded on the root node, which blocks
+	first := models.NewRun("sync", "lab")
+	first.Repositories = []string{"rhel7-*"}
+	if err := runner.Start(first); err != nil {
+		t.Fatal(err)
+	}
+	if !runner.IsRunning("lab") {
+		t.Errorf("stage 'lab' is not running")
+	}
+
+	second := models.NewRun("sync", "lab")
+	second.Repositories = []string{"rhel7-*"}
+	err = runner.Start(second)
+	if err == nil || !strings.Contains(err.Error(), "stage 'lab' is busy with run "+first.Id) {
+		t.Errorf("expected the second run of 'lab' to be refused, got %v", err)
+	}
+
+	// other stages are not blocked
+	other := models.NewRun("sync", "prd")
+	other.Repositories = []string{"rhel7-*"}
+	if err := runner.Start(other); err != nil {
+		t.Errorf("the run of 'prd' is refused: %v", err)
+	}
+
+	close(release)
+	<-finished
+	<-finished
+	if runner.IsRunning("lab") || runner.IsRunning("prd") {
+		t.Errorf("the stages are still running")
+	}
+
+	// the stage accepts runs again once the first one is done
+	third := models.NewRun("sync", "lab")
+	third.Repositories = []string{"rhel7-*"}
+	if err := runner.Start(third); err != nil {
+		t.Errorf("the run after the first one is refused: %v", err)
+	}
+	<-finished
+}
+
+func TestRunnerForgetsOldRuns(t *testing.T) {
+	runner := NewRunner(&models.StageTree{})
+	runner.KeptRuns = 2
+
+	busy := models.NewRun("sync", "lab")
+	runner.running["lab"] = busy
+	runner.runs = append(runner.runs, busy)
+	for i := 0; i < 4; i++ {
+		runner.runs = append(runner.runs, models.NewRun("sync", "prd"))
+	}
+	last := runner.runs[len(runner.runs)-2:]
+	runner.forgetRuns()
+
+	runs := runner.Runs()
+	if len(runs) != 3 || runs[0] != busy || runs[1] != last[0] || runs[2] != last[1] {
+		t.Errorf("expected the running run and the last 2 finished runs, got %v run(s)", len(runs))
+	}
+}
+
+// a pulp node answering the status and repository apis, counting the started syncs
+func newPreflightTestNode(liveWorkers bool, syncs *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/status/"):
+			workers := "[]"
+			if liveWorkers {
+				workers = `[{"_id": "reserved_resource_worker-0@node"}]`
+			}
+			w.Write([]byte(`{"database_connection": {"connected": true}, "messaging_connection": {"connected": true}, "known_workers": ` + workers + `}`))
+		case strings.HasSuffix(r.URL.Path, "/repositories/"):
+			w.Write([]byte(`[{"id": "rhel7-os"}]`))
+		case strings.HasSuffix(r.URL.Path, "/actions/sync/"):
+			atomic.AddInt32(syncs, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestRunnerRunsThePreflight(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "nodetree-runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	viper.Set("state_dir", stateDir)
+	defer viper.Set("state_dir", "")
+
+	var syncs int32
+	root := newPreflightTestNode(true, &syncs)
+	defer root.Close()
+	child := newPreflightTestNode(false, &syncs)
+	defer child.Close()
+
+	tree := &models.StageTree{
+		Stages: []*models.Stage{
+			{
+				Name:      "lab",
+				Preflight: true,
+				PulpRootNode: &models.Node{
+					Fqdn:     strings.TrimPrefix(root.URL, "http://"),
+					Children: []*models.Node{{Fqdn: strings.TrimPrefix(child.URL, "http://")}},
+				},
+			},
+		},
+	}
+	tree.Init()
+
+	runner := NewRunner(tree)
+	finished := make(chan *models.Run, 1)
+	runner.FinishHandlers = append(runner.FinishHandlers, func(run *models.Run) {
+		finished <- run
+	})
+
+	run := models.NewRun("sync", "lab")
+	run.Repositories = []string{"rhel7-os"}
+	if err := runner.Start(run); err != nil {
+		t.Fatal(err)
+	}
+	<-finished
+
+	if run.State != "failed" || !strings.Contains(run.Message, "preflight failed on 1 node(s)") {
+		t.Errorf("expected the run to be aborted by the preflight, got '%v': %v", run.State, run.Message)
+	}
+	if n := atomic.LoadInt32(&syncs); n != 0 {
+		t.Errorf("%v sync(s) started despite the failed preflight", n)
+	}
+}
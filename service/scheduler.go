@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/msutter/nodetree/models"
+	"time"
+)
+
+// Starts the scheduled runs of a tree on their cron expressions
+type Scheduler struct {
+	Runner  *Runner
+	entries []*scheduleEntry
+	// called on each triggered schedule, with the error if the run was not started
+	OnTrigger func(*models.Schedule, *models.Run, error)
+}
+
+type scheduleEntry struct {
+	schedule *models.Schedule
+	cron     *CronSchedule
+}
+
+// Build a scheduler for the schedules of the runner tree
+func NewScheduler(runner *Runner) (sc *Scheduler, err error) {
+	sc = &Scheduler{Runner: runner}
+	for _, schedule := range runner.Tree.Schedules {
+		if schedule.Name == "" {
+			return nil, errors.New("schedule without a name")
+		}
+		if runner.Tree.GetStageByName(schedule.Stage) == nil {
+			errorMsg := fmt.Sprintf("schedule '%v': stage '%v' not found", schedule.Name, schedule.Stage)
+			return nil, errors.New(errorMsg)
+		}
+		if command := schedule.GetCommand(); command != "sync" && command != "check" {
+			errorMsg := fmt.Sprintf("schedule '%v': unknown command '%v'", schedule.Name, command)
+			return nil, errors.New(errorMsg)
+		}
+		if len(schedule.Repositories) == 0 {
+			errorMsg := fmt.Sprintf("schedule '%v' needs repositories", schedule.Name)
+			return nil, errors.New(errorMsg)
+		}
+		cron, err := ParseCron(schedule.Cron)
+		if err != nil {
+			errorMsg := fmt.Sprintf("schedule '%v': %v", schedule.Name, err)
+			return nil, errors.New(errorMsg)
+		}
+		sc.entries = append(sc.entries, &scheduleEntry{schedule: schedule, cron: cron})
+	}
+	return
+}
+
+// Get the next start time of the schedule
+func (sc *Scheduler) Next(name string, after time.Time) time.Time {
+	for _, entry := range sc.entries {
+		if entry.schedule.Name == name {
+			return entry.cron.Next(after)
+		}
+	}
+	return time.Time{}
+}
+
+// Start the runs of the schedules matching the minute of the given time
+func (sc *Scheduler) Trigger(t time.Time) {
+	for _, entry := range sc.entries {
+		if !entry.cron.Matches(t) {
+			continue
+		}
+		run := entry.schedule.NewRun()
+		err := sc.Runner.Start(run)
+		if sc.OnTrigger != nil {
+			sc.OnTrigger(entry.schedule, run, err)
+		}
+	}
+}
+
+// Trigger the schedules at the start of each minute. Never returns.
+func (sc *Scheduler) Run() {
+	for {
+		now := time.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		time.Sleep(next.Sub(now))
+		sc.Trigger(next)
+	}
+}